@@ -57,6 +57,10 @@ func Test_Integration(t *testing.T) {
 	o, e = i.Set("metrics-server.deploy.test.namespace.inject", "true", "bool", false)
 	assert.Assert(t, o == "" && e == "")
 
+	// set several values together from stdin
+	i.in.Write([]byte("metrics-server.deploy.test.values.replicas=1:int\nmetrics-server.deploy.test.labels.team=platform\n"))
+	o, e = i.SetBatch()
+	assert.Assert(t, o == "" && e == "")
 	// generate
 	o, e = i.Generate()
 	assert.Assert(t, o == "" && e == "")
@@ -90,6 +94,16 @@ func Test_Integration(t *testing.T) {
 	assert.Assert(t, strings.Contains(o, "appVersion: 0.6.1") == true)
 	assert.Equal(t, e, "")
 
+	// show chart and deploy selected by label without a deploy id
+	o, e = i.ShowSelected("chart", "team=platform")
+	assert.Assert(t, strings.HasPrefix(o, "# test.metrics-server\n"))
+	assert.Assert(t, strings.Contains(o, "appVersion: 0.6.1"))
+	assert.Equal(t, e, "")
+	o, e = i.DeploySelected("team=platform")
+	assert.Assert(t, strings.HasPrefix(o, "- "))
+	assert.Assert(t, strings.Contains(o, "team: platform"))
+	assert.Equal(t, e, "")
+
 	// verify
 	o, e = i.Verify()
 	expected = "deploy is consistent with configuration\ncharts in lock file are consistent\n"
@@ -268,6 +282,12 @@ func (i integration) Set(key string, value string, as string, stdin bool) (strin
 	return i.runCmd(rootCmd)
 }
 
+func (i integration) SetBatch() (string, string) {
+	defaultFlags()
+	rootCmd.SetArgs([]string{"set", "--batch", "-w", i.workDir})
+	return i.runCmd(rootCmd)
+}
+
 func (i integration) Generate() (string, string) {
 	defaultFlags()
 	rootCmd.SetArgs([]string{"generate", "-w", i.workDir})
@@ -300,6 +320,18 @@ func (i integration) Show(thing string, id string) (string, string) {
 	return i.runCmd(rootCmd)
 }
 
+func (i integration) ShowSelected(thing string, selector string) (string, string) {
+	defaultFlags()
+	rootCmd.SetArgs([]string{"show", thing, "--selector", selector, "-w", i.workDir})
+	return i.runCmd(rootCmd)
+}
+
+func (i integration) DeploySelected(selector string) (string, string) {
+	defaultFlags()
+	rootCmd.SetArgs([]string{"deploy", "--selector", selector, "-w", i.workDir})
+	return i.runCmd(rootCmd)
+}
+
 func (i integration) Verify() (string, string) {
 	defaultFlags()
 	rootCmd.SetArgs([]string{"verify", "-w", i.workDir})
@@ -8,30 +8,41 @@ import (
 )
 
 var containerResourcesCmd = &cobra.Command{
-	Use:   "container-resources [environment.component]",
+	Use:   "container-resources [selector]",
 	Short: "show container-resource configuration in ",
 	Args:  cobra.RangeArgs(0, 1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if len(args) == 1 {
+		sel, err := newSelector(args)
+		if err != nil {
+			return err
+		}
+		if sel.Exact() {
 			env, comp, err := cfg.DeployIdParts(args[0])
 			if err != nil {
 				return err
 			}
-			return ContainerResourcesForDeploy(comp, env, cmd.OutOrStdout(), newConfigService(), newMatcherService())
+			return ContainerResourcesForDeploy(comp, env, cmd.OutOrStdout(), cmd.ErrOrStderr(), newConfigService(), newMatcherService())
 		}
-		return ContainerResourcesForDeploys(cmd.OutOrStdout(), newConfigService(), newMatcherService())
+		return ContainerResourcesForDeploys(sel, cmd.OutOrStdout(), newConfigService(), newMatcherService())
 	},
 }
 
 func init() {
+	addSelectorFlag(containerResourcesCmd)
 	rootCmd.AddCommand(containerResourcesCmd)
 }
 
-func ContainerResourcesForDeploy(compName string, envName string, w io.Writer, config *cfg.Svc, match *matcher.Svc) error {
+// ContainerResourcesForDeploy lists the container resources of a deploy. A
+// disabled deploy has no resources and is reported as disabled on e.
+func ContainerResourcesForDeploy(compName string, envName string, w io.Writer, e io.Writer, config *cfg.Svc, match *matcher.Svc) error {
 	deploy, err := config.GetDeploy(compName, envName)
 	if err != nil {
 		return err
 	}
+	if deploy.Disabled {
+		reportDisabled(deploy, e)
+		return response(matcher.ContainerResources{}, w)
+	}
 	manifestPath, err := config.ManifestPath(deploy)
 	if err != nil {
 		return err
@@ -45,16 +56,21 @@ func ContainerResourcesForDeploy(compName string, envName string, w io.Writer, c
 
 type DeployContainerResources struct {
 	Name      string
+	Disabled  bool `json:",omitempty"`
 	Resources matcher.ContainerResources
 }
 
-func ContainerResourcesForDeploys(w io.Writer, config *cfg.Svc, match *matcher.Svc) error {
+func ContainerResourcesForDeploys(sel cfg.Selector, w io.Writer, config *cfg.Svc, match *matcher.Svc) error {
 	var result []DeployContainerResources
-	deploys, err := config.Deploys()
+	deploys, err := config.SelectDeploys(sel)
 	if err != nil {
 		return err
 	}
 	for _, d := range deploys {
+		if d.Disabled {
+			result = append(result, DeployContainerResources{Name: d.Id(), Disabled: true})
+			continue
+		}
 		manifestPath, err := config.ManifestPath(d)
 		if err != nil {
 			return err
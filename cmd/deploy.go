@@ -1,21 +1,37 @@
 package cmd
 
 import (
+	"errors"
 	"github.com/richardjennings/simple-ops/internal/cfg"
 	"github.com/spf13/cobra"
 	"io"
 )
 
 var DepoyCmd = &cobra.Command{
-	Use:   "deploy <deployId>",
+	Use:   "deploy [selector]",
 	Short: "deploy",
-	Args:  cobra.ExactArgs(1),
+	Args:  selectorArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		sel, err := newSelector(args)
+		if err != nil {
+			return err
+		}
+		if !sel.Exact() {
+			if flags.deployExplain {
+				return errors.New("--explain requires a single deploy id")
+			}
+			return DeploysFn(cmd.OutOrStdout(), sel, newConfigService())
+		}
+		if flags.deployExplain {
+			return ExplainFn(cmd.OutOrStdout(), args[0], newConfigService())
+		}
 		return DeployFn(cmd.OutOrStdout(), args[0], newConfigService())
 	},
 }
 
 func init() {
+	addSelectorFlag(DepoyCmd)
+	DepoyCmd.PersistentFlags().BoolVar(&flags.deployExplain, "explain", false, "show the file and line each value was set and the values it overrode")
 	rootCmd.AddCommand(DepoyCmd)
 }
 
@@ -30,3 +46,25 @@ func DeployFn(w io.Writer, id string, config *cfg.Svc) error {
 	}
 	return response(dep, w)
 }
+
+// DeploysFn writes the merged configuration of the deploys matching sel
+func DeploysFn(w io.Writer, sel cfg.Selector, config *cfg.Svc) error {
+	deps, err := config.SelectDeploys(sel)
+	if err != nil {
+		return err
+	}
+	return response(deps, w)
+}
+
+// ExplainFn writes every value of a deploy with where it was set
+func ExplainFn(w io.Writer, id string, config *cfg.Svc) error {
+	env, comp, err := cfg.DeployIdParts(id)
+	if err != nil {
+		return err
+	}
+	explanations, err := config.Explain(comp, env)
+	if err != nil {
+		return err
+	}
+	return response(explanations, w)
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/richardjennings/simple-ops/internal/cfg"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+var envsCmd = &cobra.Command{
+	Use:   "envs",
+	Short: "list environments and the components deployed to them",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return EnvsFn(cmd.OutOrStdout(), newConfigService())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(envsCmd)
+}
+
+func EnvsFn(w io.Writer, config *cfg.Svc) error {
+	envs, err := config.EnvironmentSummaries()
+	if err != nil {
+		return err
+	}
+	return response(envs, w)
+}
@@ -7,23 +7,36 @@ import (
 )
 
 var generateCmd = &cobra.Command{
-	Use:   "generate",
+	Use:   "generate [selector]",
 	Short: "generate deployment manifests from config",
-	RunE: func(cmd *cobra.Command, _ []string) error {
-		return GenerateFn(newConfigService(), newManifestService())
+	Args:  cobra.RangeArgs(0, 1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sel, err := newSelector(args)
+		if err != nil {
+			return err
+		}
+		return GenerateFn(sel, newConfigService(), newManifestService())
 	},
 }
 
 func init() {
+	addSelectorFlag(generateCmd)
+	addConcurrencyFlag(generateCmd)
+	generateCmd.PersistentFlags().BoolVar(&flags.noCache, "no-cache", false, "render every deploy, ignoring the hashes of the previous generate, which are replaced")
 	rootCmd.AddCommand(generateCmd)
 }
 
-func GenerateFn(config *cfg.Svc, manifests *manifest.Svc) error {
+// GenerateFn generates the manifests of every deploy, or with a selector
+// only those of the selected deploys
+func GenerateFn(sel cfg.Selector, config *cfg.Svc, manifests *manifest.Svc) error {
 	var deploys cfg.Deploys
 	var err error
-	deploys, err = config.Deploys()
+	deploys, err = config.SelectDeploys(sel)
 	if err != nil {
 		return err
 	}
-	return manifests.Generate(deploys)
+	if sel.Empty() {
+		return manifests.Generate(deploys)
+	}
+	return manifests.GenerateDeploys(deploys)
 }
@@ -9,31 +9,46 @@ import (
 )
 
 var imageCmd = &cobra.Command{
-	Use:   "images [subcommand]",
+	Use:   "images [selector]",
 	Short: "list images in manifests",
 	Args:  cobra.RangeArgs(0, 1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		w := cmd.OutOrStdout()
-		if len(args) == 1 {
+		sel, err := newSelector(args)
+		if err != nil {
+			return err
+		}
+		if flags.imagesByDeploy {
+			return ImagesByDeploy(sel, w, newConfigService(), newManifestService(), newMatcherService())
+		}
+		if sel.Exact() {
 			env, comp, err := cfg.DeployIdParts(args[0])
 			if err != nil {
 				return err
 			}
-			return ImagesForDeploy(env, comp, w, newConfigService(), newManifestService(), newMatcherService())
+			return ImagesForDeploy(env, comp, w, cmd.ErrOrStderr(), newConfigService(), newManifestService(), newMatcherService())
 		}
-		return AllImages(w, newConfigService(), newManifestService(), newMatcherService())
+		return ImagesForDeploys(sel, w, cmd.ErrOrStderr(), newConfigService(), newManifestService(), newMatcherService())
 	},
 }
 
 func init() {
+	addSelectorFlag(imageCmd)
+	imageCmd.Flags().BoolVar(&flags.imagesByDeploy, "by-deploy", false, "list the images of each deploy, and disabled deploys as disabled")
 	rootCmd.AddCommand(imageCmd)
 }
 
-func ImagesForDeploy(environment string, component string, w io.Writer, config *cfg.Svc, manifests *manifest.Svc, match *matcher.Svc) error {
+// ImagesForDeploy lists the images of a deploy. A disabled deploy has no
+// images and is reported as disabled on e.
+func ImagesForDeploy(environment string, component string, w io.Writer, e io.Writer, config *cfg.Svc, manifests *manifest.Svc, match *matcher.Svc) error {
 	d, err := config.GetDeploy(component, environment)
 	if err != nil {
 		return err
 	}
+	if d.Disabled {
+		reportDisabled(d, e)
+		return response(matcher.Images{}, w)
+	}
 	imgs, err := match.Images(manifests.ManifestPathForDeploy(d))
 	if err != nil {
 		return err
@@ -41,16 +56,22 @@ func ImagesForDeploy(environment string, component string, w io.Writer, config *
 	return response(imgs, w)
 }
 
-func AllImages(w io.Writer, config *cfg.Svc, manifests *manifest.Svc, match *matcher.Svc) error {
+// ImagesForDeploys lists the unique images of the deploys matching sel.
+// Disabled deploys are reported as disabled on e.
+func ImagesForDeploys(sel cfg.Selector, w io.Writer, e io.Writer, config *cfg.Svc, manifests *manifest.Svc, match *matcher.Svc) error {
 	var images matcher.Images
 	var imgs matcher.Images
 	var deploys cfg.Deploys
 	var err error
-	deploys, err = config.Deploys()
+	deploys, err = config.SelectDeploys(sel)
 	if err != nil {
 		return err
 	}
 	for _, d := range deploys {
+		if d.Disabled {
+			reportDisabled(d, e)
+			continue
+		}
 		imgs, err = match.Images(manifests.ManifestPathForDeploy(d))
 		if err != nil {
 			return err
@@ -59,3 +80,31 @@ func AllImages(w io.Writer, config *cfg.Svc, manifests *manifest.Svc, match *mat
 	}
 	return response(images.Unique(), w)
 }
+
+type DeployImages struct {
+	Name     string
+	Disabled bool `json:",omitempty"`
+	Images   matcher.Images
+}
+
+// ImagesByDeploy lists the unique images of each deploy matching sel.
+// Disabled deploys are listed as disabled without images.
+func ImagesByDeploy(sel cfg.Selector, w io.Writer, config *cfg.Svc, manifests *manifest.Svc, match *matcher.Svc) error {
+	var result []DeployImages
+	deploys, err := config.SelectDeploys(sel)
+	if err != nil {
+		return err
+	}
+	for _, d := range deploys {
+		if d.Disabled {
+			result = append(result, DeployImages{Name: d.Id(), Disabled: true})
+			continue
+		}
+		imgs, err := match.Images(manifests.ManifestPathForDeploy(d))
+		if err != nil {
+			return err
+		}
+		result = append(result, DeployImages{Name: d.Id(), Images: matcher.Images(imgs).Unique()})
+	}
+	return response(result, w)
+}
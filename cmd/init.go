@@ -25,15 +25,20 @@ func InitFn(force bool, config *cfg.Svc) error {
 	return config.InitIfEmpty(configTemplate)
 }
 
-var configTemplate = `
+var configTemplate = `apiVersion: simple-ops/v1
+
 # fsslice.labels configures the kustomizable field paths in k8s API resources applicable to labels, 
 # optionally creating field paths in resources if they do not exist.
+# fsslice.namespace configures field paths the injected namespace is set at in addition to metadata/namespace.
 fsslice:
   labels:
     - path: metadata/labels
       create: true
     - path: spec/template/metadata/labels
       create: false
+    - path: spec/jobTemplate/spec/template/metadata/labels
+      kind: CronJob
+      create: false
 
 # apply this label to all resources matched by fsslice.labels
 labels:
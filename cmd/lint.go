@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/simple-ops/internal/cfg"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "validate config files against the config schema",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return LintFn(cmd.OutOrStdout(), flags.lintSchema, newConfigService())
+	},
+}
+
+func init() {
+	lintCmd.PersistentFlags().BoolVar(&flags.lintSchema, "schema", false, "print the config JSON Schema")
+	rootCmd.AddCommand(lintCmd)
+}
+
+func LintFn(w io.Writer, printSchema bool, config *cfg.Svc) error {
+	if printSchema {
+		_, err := w.Write(cfg.Schema)
+		return err
+	}
+	diags, err := config.Lint()
+	if err != nil {
+		return err
+	}
+	for _, d := range diags {
+		if _, err := fmt.Fprintln(w, d.String()); err != nil {
+			return err
+		}
+	}
+	if len(diags) > 0 {
+		return fmt.Errorf("%d problems found in config", len(diags))
+	}
+	_, err = fmt.Fprintln(w, "config is valid")
+	return err
+}
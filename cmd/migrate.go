@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/simple-ops/internal/cfg"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "convert config files to the current config apiVersion",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return MigrateFn(cmd.OutOrStdout(), newConfigService())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+}
+
+func MigrateFn(w io.Writer, config *cfg.Svc) error {
+	changed, err := config.Migrate()
+	if err != nil {
+		return err
+	}
+	for _, path := range changed {
+		if _, err := fmt.Fprintf(w, "migrated %s to %s\n", path, cfg.APIVersion); err != nil {
+			return err
+		}
+	}
+	if len(changed) == 0 {
+		_, err = fmt.Fprintf(w, "config is %s\n", cfg.APIVersion)
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/richardjennings/simple-ops/internal/cfg"
+	"github.com/richardjennings/simple-ops/internal/manifest"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render <environment.component>",
+	Short: "render the manifest of a deploy to stdout without changing the working tree",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := manifest.RenderOptions{CRDs: flags.renderCRDs, WithPaths: flags.renderWithPaths, Until: flags.renderUntil}
+		return RenderFn(cmd.OutOrStdout(), args[0], opts, newConfigService(), newManifestService())
+	},
+}
+
+func init() {
+	renderCmd.PersistentFlags().BoolVar(&flags.renderCRDs, "crds", false, "include chart CRDs")
+	renderCmd.PersistentFlags().BoolVar(&flags.renderWithPaths, "with-paths", false, "include with templates rendered to a path")
+	renderCmd.PersistentFlags().StringVar(&flags.renderUntil, "until", "", "stop after the named chain step, e.g. labels")
+	rootCmd.AddCommand(renderCmd)
+}
+
+// RenderFn writes the manifest of the deploy id to w
+func RenderFn(w io.Writer, id string, opts manifest.RenderOptions, config *cfg.Svc, manifests *manifest.Svc) error {
+	env, comp, err := cfg.DeployIdParts(id)
+	if err != nil {
+		return err
+	}
+	d, err := config.GetDeploy(comp, env)
+	if err != nil {
+		return err
+	}
+	return manifests.Render(d, opts, w)
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/ghodss/yaml"
 	"github.com/richardjennings/simple-ops/internal/cfg"
@@ -17,15 +18,27 @@ import (
 )
 
 type options struct {
-	verbosity     string
-	workdir       string
-	output        string
-	addRepository string
-	addVersion    string
-	addConfig     bool
-	initForce     bool
-	setStdin      bool
-	setType       string
+	verbosity       string
+	workdir         string
+	output          string
+	addRepository   string
+	addVersion      string
+	addConfig       bool
+	initForce       bool
+	setStdin        bool
+	setType         string
+	setBatch        bool
+	setExpect       optionalString
+	setIfAbsent     bool
+	lintSchema      bool
+	deployExplain   bool
+	selector        string
+	concurrency     int
+	noCache         bool
+	renderCRDs      bool
+	renderWithPaths bool
+	renderUntil     string
+	imagesByDeploy  bool
 }
 
 var flags options
@@ -52,6 +65,18 @@ func defaultFlags() {
 	flags.initForce = false
 	flags.setStdin = false
 	flags.setType = "string"
+	flags.setBatch = false
+	flags.setExpect = optionalString{}
+	flags.setIfAbsent = false
+	flags.lintSchema = false
+	flags.deployExplain = false
+	flags.selector = ""
+	flags.concurrency = 1
+	flags.noCache = false
+	flags.renderCRDs = false
+	flags.renderWithPaths = false
+	flags.renderUntil = ""
+	flags.imagesByDeploy = false
 }
 
 func init() {
@@ -68,8 +93,26 @@ func init() {
 			return err
 		}
 		flags.workdir = w
-		return os.Chdir(w)
+		if err := os.Chdir(w); err != nil {
+			return err
+		}
+		warnLegacyConfig(cmd)
+		return nil
+	}
+}
+
+// warnLegacyConfig warns once per command on stderr, whatever the log
+// level, if config files are of an older apiVersion, other than for
+// commands creating or migrating config
+func warnLegacyConfig(cmd *cobra.Command) {
+	if cmd == initCmd || cmd == migrateCmd {
+		return
+	}
+	version, err := newConfigService().APIVersion()
+	if err != nil || version == cfg.APIVersion {
+		return
 	}
+	_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s is %s config, run simple-ops migrate to convert it to %s\n", cfg.GlobalConfigFile, version, cfg.APIVersion)
 }
 
 func initConfig() {
@@ -80,13 +123,28 @@ func initConfig() {
 	log.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
 }
 
+// ExitPreconditionFailed is the exit code when a set --expect or
+// --if-absent condition is not met
+const ExitPreconditionFailed = 3
+
 // Execute executes the root command.
 func Execute() error {
 	return rootCmd.Execute()
 }
 
+// ExitCode returns the process exit code for an error returned by Execute
+func ExitCode(err error) int {
+	if errors.Is(err, cfg.ErrPreconditionFailed) {
+		return ExitPreconditionFailed
+	}
+	return 1
+}
+
 func newManifestService() *manifest.Svc {
-	return manifest.NewSvc(fs, flags.workdir, log)
+	m := manifest.NewSvc(fs, flags.workdir, log)
+	m.SetConcurrency(flags.concurrency)
+	m.SetCache(!flags.noCache)
+	return m
 }
 
 func newConfigService() *cfg.Svc {
@@ -105,6 +163,44 @@ func newMatcherService() *matcher.Svc {
 	return matcher.NewSvc(fs, flags.workdir, log)
 }
 
+// addSelectorFlag adds the --selector flag selecting deploys by tag
+func addSelectorFlag(c *cobra.Command) {
+	c.PersistentFlags().StringVarP(&flags.selector, "selector", "l", "", "select deploys by tags, e.g. platform,tier=1,!beta")
+}
+
+// selectorArgs accepts n arguments, the last a deploy id glob, or n-1 when
+// the --selector flag selects the deploys instead
+func selectorArgs(n int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) == n-1 && flags.selector != "" {
+			return nil
+		}
+		return cobra.ExactArgs(n)(cmd, args)
+	}
+}
+
+// addConcurrencyFlag adds the --concurrency flag to commands rendering
+// deploys
+func addConcurrencyFlag(c *cobra.Command) {
+	c.PersistentFlags().IntVar(&flags.concurrency, "concurrency", 1, "number of deploys to render in parallel")
+}
+
+// reportDisabled notes on e that deploy d is disabled, for commands
+// reporting on a deploy
+func reportDisabled(d *cfg.Deploy, e io.Writer) {
+	_, _ = fmt.Fprintf(e, "deploy %s is disabled\n", d.Id())
+}
+
+// newSelector returns the selector for an optional deploy id glob argument
+// and the --selector flag
+func newSelector(args []string) (cfg.Selector, error) {
+	var id string
+	if len(args) > 0 {
+		id = args[len(args)-1]
+	}
+	return cfg.ParseSelector(id, flags.selector)
+}
+
 func response(l interface{}, w io.Writer) error {
 	switch flags.output {
 	case "yaml":
@@ -2,19 +2,33 @@ package cmd
 
 import (
 	"errors"
-	"github.com/ghodss/yaml"
 	"github.com/richardjennings/simple-ops/internal/cfg"
 	"github.com/spf13/cobra"
 	"io/ioutil"
-	"strconv"
 )
 
 var setCmd = &cobra.Command{
 	Use:   "set",
 	Short: "modify configuration",
-	Args:  cobra.RangeArgs(1, 2),
+	Args:  cobra.RangeArgs(0, 2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var value string
+		if flags.setBatch {
+			if len(args) != 0 {
+				return errors.New("expected no arguments with --batch")
+			}
+			if flags.setExpect.set || flags.setIfAbsent {
+				return errors.New("--expect and --if-absent are not supported with --batch")
+			}
+			b, err := ioutil.ReadAll(cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			return SetBatchFn(b, newConfigService())
+		}
+		if len(args) == 0 {
+			return errors.New("expected at least 1 argument")
+		}
 		if flags.setStdin {
 			v, err := ioutil.ReadAll(cmd.InOrStdin())
 			if err != nil {
@@ -22,38 +36,66 @@ var setCmd = &cobra.Command{
 			}
 			value = string(v)
 		} else {
-			if len(args) != 2 {
+			if len(args) == 2 {
+				value = args[1]
+			} else if flags.setType != "null" {
 				return errors.New("expected 2 arguments")
 			}
-			value = args[1]
 		}
-		return SetFn(args[0], value, flags.setType, newConfigService())
+		var expect *string
+		if flags.setExpect.set {
+			expect = &flags.setExpect.value
+		}
+		return SetFn(args[0], value, flags.setType, expect, flags.setIfAbsent, newConfigService())
 	},
 }
 
+// optionalString is a string flag value recording whether it was given,
+// such that an empty value can be told apart from no value
+type optionalString struct {
+	value string
+	set   bool
+}
+
+func (o *optionalString) String() string {
+	return o.value
+}
+
+func (o *optionalString) Set(v string) error {
+	o.value, o.set = v, true
+	return nil
+}
+
+func (o *optionalString) Type() string {
+	return "string"
+}
+
 func init() {
 	setCmd.PersistentFlags().BoolVar(&flags.setStdin, "stdin", false, "")
-	setCmd.PersistentFlags().StringVar(&flags.setType, "type", "", "--type [string, bool, int]")
+	setCmd.PersistentFlags().StringVar(&flags.setType, "type", "", "--type [string, bool, int, float, null, json, yaml]")
+	setCmd.PersistentFlags().Var(&flags.setExpect, "expect", "only set the value if the current value is the given value")
+	setCmd.PersistentFlags().BoolVar(&flags.setIfAbsent, "if-absent", false, "only set the value if no value is set")
+	setCmd.PersistentFlags().BoolVar(&flags.setBatch, "batch", false, "read path=value[:type] lines or a yaml patch document from stdin and apply them together")
 	rootCmd.AddCommand(setCmd)
 }
 
-func SetFn(path string, value string, setType string, config *cfg.Svc) error {
-	var v interface{}
-	var err error
-	switch setType {
-	case "bool":
-		v, err = strconv.ParseBool(value)
-		cobra.CheckErr(err)
-	case "int":
-		v, err = strconv.Atoi(value)
-		cobra.CheckErr(err)
-	case "yaml":
-		v = make(map[string]interface{})
-		if err := yaml.Unmarshal([]byte(value), &v); err != nil {
-			return err
-		}
-	default:
-		v = value
+// SetFn sets path to value. When expect is not nil the current value must
+// equal expect, when ifAbsent is true the path must not have a value.
+func SetFn(path string, value string, setType string, expect *string, ifAbsent bool, config *cfg.Svc) error {
+	v, err := cfg.ParseValue(value, setType)
+	if err != nil {
+		return err
+	}
+	return config.SetBatch([]cfg.Edit{{Path: path, Value: v, Expect: expect, IfAbsent: ifAbsent}})
+}
+
+// SetBatchFn applies every edit in input or none of them. Input is either
+// a yaml patch document, a mapping of config to set keyed first by
+// component, or lines of path=value[:type].
+func SetBatchFn(input []byte, config *cfg.Svc) error {
+	edits, err := cfg.ParseBatch(input)
+	if err != nil {
+		return err
 	}
-	return config.Set(path, v)
+	return config.SetBatch(edits)
 }
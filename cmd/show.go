@@ -9,19 +9,27 @@ import (
 )
 
 var showCmd = &cobra.Command{
-	Use:   "show <type> <environment.component>",
+	Use:   "show <type> [selector]",
 	Short: "show details from a deploy config helm chart",
-	Args:  cobra.ExactArgs(2),
+	Args:  selectorArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		env, comp, err := cfg.DeployIdParts(args[1])
+		sel, err := newSelector(args[1:])
 		if err != nil {
 			return err
 		}
-		return ShowFn(args[0], env, comp, cmd.OutOrStdout(), newConfigService())
+		if sel.Exact() {
+			env, comp, err := cfg.DeployIdParts(args[1])
+			if err != nil {
+				return err
+			}
+			return ShowFn(args[0], env, comp, cmd.OutOrStdout(), newConfigService())
+		}
+		return ShowSelectedFn(args[0], sel, cmd.OutOrStdout(), newConfigService())
 	},
 }
 
 func init() {
+	addSelectorFlag(showCmd)
 	rootCmd.AddCommand(showCmd)
 }
 
@@ -30,6 +38,32 @@ func ShowFn(thing string, environment string, component string, w io.Writer, con
 	if err != nil {
 		return err
 	}
+	return showDeploy(thing, deploy, w, config)
+}
+
+// ShowSelectedFn shows details for each deploy matching sel, headed by the
+// deploy id and whether it is disabled
+func ShowSelectedFn(thing string, sel cfg.Selector, w io.Writer, config *cfg.Svc) error {
+	deploys, err := config.SelectDeploys(sel)
+	if err != nil {
+		return err
+	}
+	for _, d := range deploys {
+		header := d.Id()
+		if d.Disabled {
+			header += " (disabled)"
+		}
+		if _, err := fmt.Fprintf(w, "# %s\n", header); err != nil {
+			return err
+		}
+		if err := showDeploy(thing, d, w, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func showDeploy(thing string, deploy *cfg.Deploy, w io.Writer, config *cfg.Svc) error {
 	chartPath, err := config.ChartPath(*deploy)
 	if err != nil {
 		return err
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/richardjennings/simple-ops/internal/cfg"
+	"github.com/spf13/cobra"
+)
+
+var unsetCmd = &cobra.Command{
+	Use:   "unset <path>",
+	Short: "remove configuration",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return UnsetFn(args[0], newConfigService())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unsetCmd)
+}
+
+func UnsetFn(path string, config *cfg.Svc) error {
+	return config.Unset(path)
+}
@@ -14,26 +14,40 @@ import (
 )
 
 var verifyCmd = &cobra.Command{
-	Use:   "verify",
+	Use:   "verify [selector]",
 	Short: "verify deployment manifests match config",
-	RunE: func(cmd *cobra.Command, _ []string) error {
-		return VerifyFn(cmd.OutOrStdout(), newConfigService(), newManifestService(), newLockService(), newHashService())
+	Args:  cobra.RangeArgs(0, 1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sel, err := newSelector(args)
+		if err != nil {
+			return err
+		}
+		return VerifyFn(cmd.OutOrStdout(), sel, newConfigService(), newManifestService(), newLockService(), newHashService())
 	},
 }
 
 func init() {
+	addSelectorFlag(verifyCmd)
+	addConcurrencyFlag(verifyCmd)
 	rootCmd.AddCommand(verifyCmd)
 }
 
-func VerifyFn(w io.Writer, config *cfg.Svc, manifests *manifest.Svc, lock *cfg.Lock, h *hash.Svc) error {
+// VerifyFn verifies the deploy directory and charts are consistent with
+// configuration. With a selector only the selected deploys are verified.
+func VerifyFn(w io.Writer, sel cfg.Selector, config *cfg.Svc, manifests *manifest.Svc, lock *cfg.Lock, h *hash.Svc) error {
 	var deploys cfg.Deploys
 	var err error
 	var invalid bool
-	deploys, err = config.Deploys()
+	var correct bool
+	deploys, err = config.SelectDeploys(sel)
 	if err != nil {
 		return err
 	}
-	correct, err := manifests.Verify(deploys)
+	if sel.Empty() {
+		correct, err = manifests.Verify(deploys)
+	} else {
+		correct, err = manifests.VerifyDeploys(deploys)
+	}
 	if err != nil {
 		return err
 	}
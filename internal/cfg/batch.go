@@ -0,0 +1,192 @@
+package cfg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"strconv"
+	"strings"
+)
+
+// SetTypes are the value types understood by set
+var SetTypes = []string{"string", "bool", "int", "float", "null", "json", "yaml"}
+
+// ParseValue returns value as setType, one of SetTypes, a string if empty
+func ParseValue(value string, setType string) (interface{}, error) {
+	switch setType {
+	case "bool":
+		return strconv.ParseBool(value)
+	case "int":
+		return strconv.Atoi(value)
+	case "float":
+		return strconv.ParseFloat(value, 64)
+	case "null":
+		return nil, nil
+	case "json":
+		dec := json.NewDecoder(strings.NewReader(value))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("invalid json value: %s", err)
+		}
+		if dec.More() {
+			return nil, errors.New("invalid json value: more than one value")
+		}
+		return jsonNumbers(v)
+	case "yaml":
+		var v interface{}
+		if err := kyaml.Unmarshal([]byte(value), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	default:
+		return value, nil
+	}
+}
+
+// jsonNumbers returns json decoded with numbers as json.Number with the
+// numbers converted to integers where they are whole and fit, and floats
+// otherwise
+func jsonNumbers(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return int(i), nil
+		}
+		if i, err := strconv.ParseUint(t.String(), 10, 64); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	case map[string]interface{}:
+		for k, e := range t {
+			n, err := jsonNumbers(e)
+			if err != nil {
+				return nil, err
+			}
+			t[k] = n
+		}
+	case []interface{}:
+		for i, e := range t {
+			n, err := jsonNumbers(e)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = n
+		}
+	}
+	return v, nil
+}
+
+// ParseBatch returns the edits of input, either lines of path=value[:type]
+// or a yaml patch document, a mapping of config to set keyed first by
+// component. Input is lines when every line has an = outside quotes before
+// any :, such that values like note=see: here are not read as yaml.
+func ParseBatch(input []byte) ([]Edit, error) {
+	if isLineBatch(input) {
+		return lineEdits(input)
+	}
+	var doc kyaml.Node
+	if err := kyaml.Unmarshal(input, &doc); err == nil && len(doc.Content) > 0 && doc.Content[0].Kind == kyaml.MappingNode {
+		return patchEdits(doc.Content[0], nil)
+	}
+	return lineEdits(input)
+}
+
+// isLineBatch returns true if every line of input other than empty lines
+// and comments is path=value
+func isLineBatch(input []byte) bool {
+	for _, line := range strings.Split(string(input), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := pathEnd(line)
+		if i == -1 || unquotedIndex(line[:i], ':') != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// patchEdits flattens a yaml patch document into an edit per leaf value
+func patchEdits(n *kyaml.Node, path []string) ([]Edit, error) {
+	if n.Kind != kyaml.MappingNode || len(n.Content) == 0 {
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return nil, err
+		}
+		return []Edit{{Path: JoinPath(path...), Value: v}}, nil
+	}
+	var edits []Edit
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		p := append(append([]string{}, path...), n.Content[i].Value)
+		e, err := patchEdits(n.Content[i+1], p)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, e...)
+	}
+	return edits, nil
+}
+
+// lineEdits parses lines of path=value[:type], ignoring empty lines and
+// lines starting with #. The :type suffix is only recognised for the
+// types understood by set, such that values like image:tag are unchanged.
+func lineEdits(input []byte) ([]Edit, error) {
+	var edits []Edit
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := pathEnd(line)
+		if i == -1 {
+			return nil, fmt.Errorf("line %d: expected path=value[:type]", n)
+		}
+		path, value, setType := line[:i], line[i+1:], "string"
+		if j := strings.LastIndex(value, ":"); j != -1 {
+			for _, t := range SetTypes {
+				if value[j+1:] == t {
+					value, setType = value[:j], t
+					break
+				}
+			}
+		}
+		v, err := ParseValue(value, setType)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		edits = append(edits, Edit{Path: path, Value: v})
+	}
+	return edits, scanner.Err()
+}
+
+// pathEnd returns the index of the first = outside a quoted path part
+func pathEnd(line string) int {
+	return unquotedIndex(line, '=')
+}
+
+// unquotedIndex returns the index of the first c in s that is neither
+// escaped nor within double quotes, or -1
+func unquotedIndex(s string, c byte) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case c:
+			if !quoted {
+				return i
+			}
+		}
+	}
+	return -1
+}
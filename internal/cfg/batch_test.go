@@ -0,0 +1,69 @@
+package cfg
+
+import (
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestParseBatch(t *testing.T) {
+	for _, tc := range []struct {
+		input  string
+		expect []Edit
+	}{
+		{
+			input: "# comment\na.values.image=img:tag\na.values.replicas=3:int\n",
+			expect: []Edit{
+				{Path: "a.values.image", Value: "img:tag"},
+				{Path: "a.values.replicas", Value: 3},
+			},
+		},
+		// a value containing ": " is a line value rather than yaml
+		{
+			input:  "a.values.note=see: here\n",
+			expect: []Edit{{Path: "a.values.note", Value: "see: here"}},
+		},
+		{
+			input:  `a.labels."x:y"=z` + "\n",
+			expect: []Edit{{Path: `a.labels."x:y"`, Value: "z"}},
+		},
+		{
+			input: "a:\n  values:\n    image: img:tag\n    note: a=b\n",
+			expect: []Edit{
+				{Path: "a.values.image", Value: "img:tag"},
+				{Path: "a.values.note", Value: "a=b"},
+			},
+		},
+	} {
+		actual, err := ParseBatch([]byte(tc.input))
+		assert.NilError(t, err, tc.input)
+		assert.DeepEqual(t, tc.expect, actual)
+	}
+	_, err := ParseBatch([]byte("a.values.image\n"))
+	assert.Error(t, err, "line 1: expected path=value[:type]")
+}
+
+func TestParseValue(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		setType string
+		expect  interface{}
+	}{
+		{"a", "", "a"},
+		{"true", "bool", true},
+		{"3", "int", 3},
+		{"1.5", "float", 1.5},
+		{"", "null", nil},
+		{`{"a": "\/x", "b": [1, 2.5]}`, "json", map[string]interface{}{"a": "/x", "b": []interface{}{1, 2.5}}},
+		{"9223372036854775807", "json", 9223372036854775807},
+		{"18446744073709551615", "json", uint64(18446744073709551615)},
+		{"a:\n  b: 1\n", "yaml", map[string]interface{}{"a": map[string]interface{}{"b": 1}}},
+	} {
+		actual, err := ParseValue(tc.value, tc.setType)
+		assert.NilError(t, err, tc.value)
+		assert.DeepEqual(t, tc.expect, actual)
+	}
+	for _, value := range []string{"{", "1 2", "{'a': 1}"} {
+		_, err := ParseValue(value, "json")
+		assert.Assert(t, err != nil, value)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"path/filepath"
 	"sigs.k8s.io/kustomize/api/types"
 	"sort"
-	"strconv"
 	"strings"
 )
 
@@ -19,13 +18,19 @@ const (
 	DeployPath           = "deploy"
 	ChartsPath           = "charts"
 	ResourcesPath        = "resources"
+	EnvironmentsPath     = "environments"
 	DefaultConfigFsPerm  = 0655
 	DefaultConfigDirPerm = 0755
 	Suffix               = ".yml"
 	GlobalConfigFile     = "simple-ops.yml"
+	DefaultsFile         = "_defaults.yml"
 	LockFileName         = "simple-ops.lock"
 )
 
+// ErrPreconditionFailed is returned when an Edit Expect or IfAbsent
+// condition is not met
+var ErrPreconditionFailed = errors.New("precondition failed")
+
 type (
 	Svc struct {
 		appFs afero.Afero
@@ -35,6 +40,7 @@ type (
 	Deploy struct {
 		Namespace          Namespace                       `json:"namespace"`
 		Labels             map[string]string               `json:"labels"`
+		Tags               []string                        `json:"tags,omitempty"`
 		Chart              string                          `json:"chart"`
 		Disabled           bool                            `json:"disabled"`
 		With               Withs                           `json:"with"`
@@ -46,6 +52,11 @@ type (
 		Component          string                          `json:"-"`
 		FsSlice            map[string][]types.FieldSpec    `json:"fsslice"`
 		Chain              []string                        `json:"chain"`
+		Extends            string                          `json:"extends,omitempty"`
+		Params             map[string]interface{}          `json:"params,omitempty"`
+		MergeOrder         []string                        `json:"mergeOrder,omitempty"`
+		// KubeVersion is the kubeVersion of the declared environment
+		KubeVersion string `json:"-"`
 	}
 	Conf struct {
 		Deploy
@@ -70,7 +81,17 @@ type (
 		PathMulti string            `json:"pathMulti"`
 		Inline    string            `json:"inline"`
 	}
-	Labels         map[string]string
+	Labels map[string]string
+	// Edit is a configuration change applied by SetBatch
+	Edit struct {
+		Path  string
+		Value interface{}
+		// Expect, when not nil, is the value the path must hold for the
+		// edit to be applied
+		Expect *string
+		// IfAbsent applies the edit only if the path holds no value
+		IfAbsent bool
+	}
 	wrappedDeploys map[string]*Deploy
 )
 
@@ -81,32 +102,86 @@ func NewSvc(fs afero.Fs, wd string, log *logrus.Logger) *Svc {
 // Deploys returns configured deploys for a given config path
 func (s Svc) Deploys() (Deploys, error) {
 	var deploys []*Deploy
-	paths, err := s.getConfigPaths()
+	merged, envs, err := s.mergedConfigs()
 	if err != nil {
 		return nil, err
 	}
-	globalCfg, err := s.getGlobalConfig()
-	if err != nil {
-		return nil, err
-	}
-	for _, path := range paths {
-		m, err := s.parseConfig(path)
-		if err != nil {
-			return nil, err
-		}
-		// merge global config
-		m = MergeMaps(globalCfg, m)
-		component := componentName(path)
-		d, err := s.buildDeploys(m, component)
+	for _, md := range merged {
+		d, err := s.newDeploys(md)
 		if err != nil {
 			return nil, err
 		}
 		deploys = append(deploys, d...)
 	}
+	for _, d := range deploys {
+		if err := envs.Check(d.Environment); err != nil {
+			return nil, fmt.Errorf("deploy %s: %s", d.Id(), err)
+		}
+		if e, ok := envs[d.Environment]; ok {
+			d.KubeVersion = e.KubeVersion
+		}
+	}
 
 	return deploys, nil
 }
 
+// mergedConfigs returns the merged config of the deploys of every
+// component, with templates executed and references resolved, and the
+// declared environments
+func (s Svc) mergedConfigs() ([]*mergedDeploys, Environments, error) {
+	paths, err := s.getConfigPaths()
+	if err != nil {
+		return nil, nil, err
+	}
+	globalCfg, version, err := s.getGlobalConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	envs, err := parseEnvironments(globalCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	globalCfg = withoutKey(globalCfg, EnvironmentsKey)
+	envCfgs, err := s.getEnvironmentConfigs(version)
+	if err != nil {
+		return nil, nil, err
+	}
+	defaultCfgs := make(map[string]map[string]interface{})
+	var merged []*mergedDeploys
+	components := make([]string, 0, len(paths))
+	for component := range paths {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		path := paths[component]
+		m, err := s.parseConfig(path, version)
+		if err != nil {
+			return nil, nil, err
+		}
+		var defaults []map[string]interface{}
+		for _, p := range s.getDefaultsPaths(path) {
+			if _, ok := defaultCfgs[p]; !ok {
+				if defaultCfgs[p], err = s.parseConfig(p, version); err != nil {
+					return nil, nil, err
+				}
+			}
+			defaults = append(defaults, defaultCfgs[p])
+		}
+		md, err := mergeDeploys(globalCfg, envCfgs, defaults, withoutKey(m, "name"), component)
+		if err != nil {
+			return nil, nil, err
+		}
+		merged = append(merged, md)
+	}
+	// references may refer to the config of any deploy, so are resolved
+	// once every deploy is merged
+	if err := resolveRefs(merged); err != nil {
+		return nil, nil, err
+	}
+	return merged, envs, nil
+}
+
 func (s Svc) GetDeploy(component string, environment string) (*Deploy, error) {
 	deps, err := s.Deploys()
 	if err != nil {
@@ -165,94 +240,332 @@ func (s Svc) InitIfEmpty(template string) error {
 // The first part of the path specifies the config file, e.g.
 // myapp.deploys.staging.imgSrc would target config/myapp.yml
 // and would add or modify the imgSrc value in deploys: staging: imgSrc
+// Parts containing dots are quoted or escaped, see SplitPath.
+// The config file is edited as a yaml node tree such that comments,
+// key order and formatting are preserved.
 func (s Svc) Set(path string, value interface{}) error {
-	var b []byte
-	var err error
-	var conf map[string]interface{}
-
-	parts := strings.Split(path, ".")
-
-	configFile := filepath.Join(s.wd, ConfPath, parts[0]) + Suffix
+	return s.SetBatch([]Edit{{Path: path, Value: value}})
+}
 
-	b, err = s.appFs.ReadFile(configFile)
+// SetBatch applies edits, which may span several config files, as a single
+// transaction. Every edit is validated before any file is written and no
+// file is changed if any edit fails. Config files are locked for the
+// duration such that concurrent edits do not overwrite each other.
+func (s Svc) SetBatch(edits []Edit) error {
+	unlock, err := s.lockConfig()
 	if err != nil {
-		if os.IsNotExist(err) {
-			f, err := s.appFs.Create(configFile)
-			if err != nil {
-				return err
-			}
-			if err := f.Close(); err != nil {
+		return err
+	}
+	defer unlock()
+	paths, err := s.getComponentPaths()
+	if err != nil {
+		return err
+	}
+	var docs []*configDocument
+	byFile := make(map[string]*configDocument)
+	for _, e := range edits {
+		parts, err := SplitPath(e.Path)
+		if err != nil {
+			return err
+		}
+		if len(parts) < 2 {
+			return fmt.Errorf("invalid path %s", e.Path)
+		}
+		configFile := s.componentFile(paths, parts[0])
+		doc, ok := byFile[configFile]
+		if !ok {
+			if doc, err = s.readConfigDocument(configFile); err != nil {
 				return err
 			}
-		} else {
+			byFile[configFile] = doc
+			docs = append(docs, doc)
+		}
+		if err = checkPrecondition(doc.root(), parts[1:], e); err != nil {
 			return err
 		}
-
+		v, err := valueNode(e.Value)
+		if err != nil {
+			return err
+		}
+		if err = setNode(doc.root(), parts[1:], v); err != nil {
+			return fmt.Errorf("%s: %s", e.Path, err)
+		}
 	}
-	if err = yaml.Unmarshal(b, &conf); err != nil {
+	return s.writeConfigDocuments(docs)
+}
+
+// Unset removes a configuration path value. The first part of the path
+// specifies the component config file as with Set. Maps left empty by the removal
+// are removed, as is the config file if nothing remains in it.
+func (s Svc) Unset(path string) error {
+	parts, err := SplitPath(path)
+	if err != nil {
 		return err
 	}
-
-	if conf == nil {
-		conf = make(map[string]interface{})
+	if len(parts) < 2 {
+		return fmt.Errorf("invalid path %s", path)
 	}
 
-	if err = set(conf, parts[1:], value); err != nil {
+	unlock, err := s.lockConfig()
+	if err != nil {
 		return err
 	}
-	c, err := yaml.Marshal(conf)
+	defer unlock()
+	paths, err := s.getComponentPaths()
 	if err != nil {
 		return err
 	}
-	return s.appFs.WriteFile(configFile, c, DefaultConfigFsPerm)
+	configFile := s.componentFile(paths, parts[0])
+	if _, err := s.appFs.Stat(configFile); os.IsNotExist(err) {
+		return fmt.Errorf("path %s not found", path)
+	}
+	doc, err := s.readConfigDocument(configFile)
+	if err != nil {
+		return err
+	}
+	if err = unsetNode(doc.root(), parts[1:]); err != nil {
+		return fmt.Errorf("path %s not found", path)
+	}
+	if doc.isEmpty() {
+		return s.appFs.Remove(configFile)
+	}
+	return s.writeConfigDocument(doc)
 }
 
-func (s Svc) getGlobalConfig() (map[string]interface{}, error) {
-	b, err := s.appFs.ReadFile(filepath.Join(s.wd, GlobalConfigFile))
+// Lint validates simple-ops.yml, environments/<env>.yml, config/<component>.yml
+// and config _defaults.yml files against the config Schema, returning any
+// problems found.
+func (s Svc) Lint() (Diagnostics, error) {
+	var diags Diagnostics
+	root, err := loadSchema()
 	if err != nil {
 		return nil, err
 	}
-	m := make(map[string]interface{})
-	if err := yaml.Unmarshal(b, &m); err != nil {
+	files := map[string]string{GlobalConfigFile: "conf"}
+	envPaths, err := s.getEnvironmentPaths()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range envPaths {
+		files[path] = "deploy"
+	}
+	configPaths, err := s.getConfigPaths()
+	if err != nil {
 		return nil, err
 	}
-	return m, nil
+	for _, path := range configPaths {
+		files[path] = "conf"
+		for _, p := range s.getDefaultsPaths(path) {
+			files[p] = "conf"
+		}
+	}
+	var ordered []string
+	for path := range files {
+		ordered = append(ordered, path)
+	}
+	sort.Strings(ordered)
+	// environment keys and the default apiVersion are taken from
+	// simple-ops.yml only if it can be parsed, otherwise it is reported by
+	// validation
+	envs, _ := s.Environments()
+	_, globalVersion, err := s.getGlobalConfig()
+	if err != nil {
+		globalVersion = LegacyAPIVersion
+	}
+	for _, path := range ordered {
+		b, err := s.appFs.ReadFile(filepath.Join(s.wd, path))
+		if err != nil {
+			return nil, err
+		}
+		version := globalVersion
+		if path == GlobalConfigFile {
+			version = LegacyAPIVersion
+		}
+		d, err := validateFile(root, files[path], path, b, version)
+		if err != nil {
+			return nil, err
+		}
+		diags = append(diags, d...)
+		if files[path] == "deploy" {
+			if err := envs.Check(strings.TrimSuffix(filepath.Base(path), Suffix)); err != nil {
+				diags = append(diags, Diagnostic{File: path, Message: err.Error()})
+			}
+			continue
+		}
+		diags = append(diags, envs.lintEnvironments(path, b, version)...)
+	}
+	return diags, nil
 }
 
-// getConfigPaths produces a list of config files found
-func (s Svc) getConfigPaths() (map[string]string, error) {
-	var f afero.File
-	var files []os.FileInfo
-	var err error
-	var name string
-	path := ConfPath
+// getGlobalConfig returns simple-ops.yml converted to the current apiVersion
+// and the apiVersion it declares, which is the version of config files
+// without an apiVersion
+func (s Svc) getGlobalConfig() (map[string]interface{}, string, error) {
+	b, err := s.appFs.ReadFile(filepath.Join(s.wd, GlobalConfigFile))
+	if err != nil {
+		return nil, "", err
+	}
+	m := make(map[string]interface{})
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, "", err
+	}
+	version, err := apiVersion(m, LegacyAPIVersion)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %s", GlobalConfigFile, err)
+	}
+	return withoutKey(m, APIVersionKey), version, nil
+}
 
-	configFiles := make(map[string]string)
-	if f, err = s.appFs.Open(filepath.Join(s.wd, path)); err != nil {
+// getEnvironmentConfigs returns the config in environments/<env>.yml files
+// keyed by environment name.
+func (s Svc) getEnvironmentConfigs(version string) (map[string]map[string]interface{}, error) {
+	envCfgs := make(map[string]map[string]interface{})
+	paths, err := s.getEnvironmentPaths()
+	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		err = f.Close()
-	}()
-	if files, err = f.Readdir(0); err != nil {
+	for env, path := range paths {
+		m, err := s.parseConfig(path, version)
+		if err != nil {
+			return nil, err
+		}
+		envCfgs[env] = m
+	}
+	return envCfgs, nil
+}
+
+// getEnvironmentPaths returns the paths of environments/<env>.yml files
+// keyed by environment name. The environments directory is optional.
+func (s Svc) getEnvironmentPaths() (map[string]string, error) {
+	paths := make(map[string]string)
+	files, err := s.appFs.ReadDir(filepath.Join(s.wd, EnvironmentsPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return paths, nil
+		}
 		return nil, err
 	}
 	for _, v := range files {
-		if v.IsDir() {
+		if v.IsDir() || !strings.HasSuffix(v.Name(), Suffix) {
 			continue
 		}
-		if !strings.HasSuffix(v.Name(), Suffix) {
-			continue
+		paths[strings.TrimSuffix(v.Name(), Suffix)] = filepath.Join(EnvironmentsPath, v.Name())
+	}
+	return paths, nil
+}
+
+// getConfigPaths returns the component config files found in the config
+// directory and its subdirectories keyed by component name. The component
+// name is the path of the file relative to the config directory without
+// the suffix, e.g. team/app, unless the file declares a name.
+func (s Svc) getConfigPaths() (map[string]string, error) {
+	configFiles := make(map[string]string)
+	root := filepath.Join(s.wd, ConfPath)
+	err := s.appFs.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), Suffix) || info.Name() == DefaultsFile {
+			return nil
+		}
+		rel, err := filepath.Rel(s.wd, path)
+		if err != nil {
+			return err
+		}
+		name, err := s.declaredName(rel)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			name = componentName(strings.TrimPrefix(rel, ConfPath+string(os.PathSeparator)))
+		}
+		if other, ok := configFiles[name]; ok {
+			return fmt.Errorf("component %s is configured by both %s and %s", name, other, rel)
+		}
+		configFiles[name] = rel
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the output of a component is deploy/<environment>/<component>, which
+	// must not contain the output of another component
+	names := make([]string, 0, len(configFiles))
+	for name := range configFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		for _, other := range names[i+1:] {
+			if !strings.HasPrefix(other, name) {
+				break
+			}
+			if strings.HasPrefix(other, name+"/") {
+				return nil, fmt.Errorf("component %s of %s is nested in component %s of %s", other, configFiles[other], name, configFiles[name])
+			}
 		}
-		name = strings.TrimSuffix(v.Name(), Suffix)
-		configFiles[name] = path + string(os.PathSeparator) + v.Name()
 	}
 	return configFiles, nil
 }
 
+// getComponentPaths returns getConfigPaths, or no paths when there is no
+// config directory yet
+func (s Svc) getComponentPaths() (map[string]string, error) {
+	paths, err := s.getConfigPaths()
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	return paths, err
+}
+
+// componentFile returns the config file of component within paths, or the
+// file a new component would be created in
+func (s Svc) componentFile(paths map[string]string, component string) string {
+	if path, ok := paths[component]; ok {
+		return filepath.Join(s.wd, path)
+	}
+	return filepath.Join(s.wd, ConfPath, filepath.FromSlash(component)) + Suffix
+}
+
+// declaredName returns the name declared in the config file at path, if
+// any. Invalid files are reported when parsed, not here.
+func (s Svc) declaredName(path string) (string, error) {
+	b, err := s.appFs.ReadFile(filepath.Join(s.wd, path))
+	if err != nil {
+		return "", err
+	}
+	var c struct {
+		Name string `json:"name"`
+	}
+	_ = yaml.Unmarshal(b, &c)
+	return c.Name, nil
+}
+
+// getDefaultsPaths returns the _defaults.yml files of the directories
+// containing the component config file at path, outermost first
+func (s Svc) getDefaultsPaths(path string) []string {
+	var paths []string
+	dir := ConfPath
+	parts := strings.Split(filepath.Dir(strings.TrimPrefix(path, ConfPath+string(os.PathSeparator))), string(os.PathSeparator))
+	for i := 0; i <= len(parts); i++ {
+		if i > 0 {
+			if parts[i-1] == "." {
+				break
+			}
+			dir = filepath.Join(dir, parts[i-1])
+		}
+		p := filepath.Join(dir, DefaultsFile)
+		if ok, _ := s.appFs.Exists(filepath.Join(s.wd, p)); ok {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
 // parseConfig parses a config file into a map[string]interface{} to aid
-// merging configuration
-func (s Svc) parseConfig(path string) (map[string]interface{}, error) {
+// merging configuration, converting it to the current apiVersion from the
+// apiVersion it declares, or version if it declares none
+func (s Svc) parseConfig(path string, version string) (map[string]interface{}, error) {
 	var data []byte
 	var err error
 	var c map[string]interface{}
@@ -265,56 +578,167 @@ func (s Svc) parseConfig(path string) (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	return c, nil
+	if version, err = apiVersion(c, version); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return withoutKey(c, APIVersionKey), nil
 }
 
 // MergeMaps makes a copy of the first map, overrides the values in the copy
 // that exist in the 2nd map and returns the result. If a value in the 2nd map
 // is nil, the values from the 1st map are used.
+// Keys may carry a merge directive suffix, see MergeDirectives, changing how
+// a value is merged with the value it overrides. Keys with the value
+// DeleteMarker are removed, including keys of map items of lists.
 // copied and modified from helm 3 // ok || v == nil
 func MergeMaps(a, b map[string]interface{}) map[string]interface{} {
+	return mergeMaps(mergeMaps(nil, a), b)
+}
+
+// mergeMaps merges b into a copy of a, resolving the merge directives of b
+func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
 	out := make(map[string]interface{}, len(a))
 	for k, v := range a {
 		out[k] = v
 	}
-	for k, v := range b {
-
+	for _, k := range directiveOrder(b) {
+		v := b[k]
+		if v == DeleteMarker {
+			key, _ := splitDirective(k)
+			delete(out, key)
+			continue
+		}
+		if key, directive := splitDirective(k); directive != "" {
+			out[key] = mergeDirective(out[key], v, directive)
+			continue
+		}
 		if v, ok := v.(map[string]interface{}); ok || v == nil {
 			if bv, ok := out[k]; ok {
 				if bv, ok := bv.(map[string]interface{}); ok {
-					out[k] = MergeMaps(bv, v)
+					out[k] = mergeMaps(bv, v)
 					continue
 				}
 			}
+			if ok {
+				out[k] = mergeMaps(nil, v)
+				continue
+			}
+		}
+		if l, ok := v.([]interface{}); ok {
+			v = resolveList(l)
 		}
 		out[k] = v
 	}
 	return out
 }
 
-// buildDeploys merges parent config into Deploy config.
-func (s Svc) buildDeploys(m map[string]interface{}, component string) (Deploys, error) {
+// mergedDeploys is the merged config of the deploys of a component, keyed
+// by environment, before it is read as Deploys
+type mergedDeploys struct {
+	component string
+	config    map[string]map[string]interface{}
+	orders    map[string][]string
+}
+
+// buildDeploys merges parent config into Deploy config. For each deploy the
+// global config is merged first, then the environment config, then the
+// directory defaults outermost first, then the component config and finally
+// the deploy config.
+func (s Svc) buildDeploys(global map[string]interface{}, envCfgs map[string]map[string]interface{}, defaults []map[string]interface{}, m map[string]interface{}, component string) (Deploys, error) {
+	md, err := mergeDeploys(global, envCfgs, defaults, m, component)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveRefs([]*mergedDeploys{md}); err != nil {
+		return nil, err
+	}
+	return s.newDeploys(md)
+}
+
+// mergeDeploys merges the config of each deploy of component and executes
+// its templates
+func mergeDeploys(global map[string]interface{}, envCfgs map[string]map[string]interface{}, defaults []map[string]interface{}, m map[string]interface{}, component string) (*mergedDeploys, error) {
 	ds := make(map[string]map[string]interface{})
 
-	// parent deploy config
-	if _, ok := m["deploy"].(map[string]interface{}); ok {
-		for k, v := range m["deploy"].(map[string]interface{}) {
+	// parent deploy config, global and directory defaults deploy config
+	// applies to every component
+	parent := global
+	for _, d := range defaults {
+		parent = MergeMaps(parent, d)
+	}
+	parent = MergeMaps(parent, m)
+	if _, ok := parent["deploy"].(map[string]interface{}); ok {
+		for k, v := range parent["deploy"].(map[string]interface{}) {
 			if v != nil {
 				ds[k] = v.(map[string]interface{})
 			}
 		}
 	}
 
+	// deploys expanded from the component matrix
+	matrix, err := matrixDeploys(m)
+	if err != nil {
+		return nil, fmt.Errorf("component %s: %s", component, err)
+	}
+	for k, v := range matrix {
+		ds[k] = MergeMaps(v.(map[string]interface{}), ds[k])
+	}
+
+	// deploy blocks are merged in layers, global, directory defaults, matrix
+	// then component, such that merge directives apply to the merged parent
+	// config
+	var deployLayers []map[string]interface{}
+	for _, c := range append([]map[string]interface{}{global}, defaults...) {
+		d, _ := c["deploy"].(map[string]interface{})
+		deployLayers = append(deployLayers, d)
+	}
+	componentDeploys, _ := m["deploy"].(map[string]interface{})
+	deployLayers = append(deployLayers, matrix, componentDeploys)
+
 	// do not need deploys to be merged
 	// into child deploys
-	delete(m, "deploy")
-	// merge
+	global = withoutKey(global, "deploy")
+	m = withoutKey(withoutKey(m, "deploy"), "matrix")
+	// merge component config, then any extended deploys, then the deploy
+	merged := make(map[string]map[string]interface{}, len(ds))
+	orders := make(map[string][]string, len(ds))
+	envs := make([]string, 0, len(ds))
 	for k := range ds {
-		ds[k] = MergeMaps(m, ds[k])
+		envs = append(envs, k)
 	}
+	sort.Strings(envs)
+	for _, k := range envs {
+		order, err := extendsOrder(ds, k)
+		if err != nil {
+			return nil, err
+		}
+		c := MergeMaps(global, withoutKey(envCfgs[k], "deploy"))
+		for _, d := range defaults {
+			c = MergeMaps(c, withoutKey(d, "deploy"))
+		}
+		c = MergeMaps(c, m)
+		for _, e := range order {
+			for _, blocks := range deployLayers {
+				if d, ok := blocks[e].(map[string]interface{}); ok {
+					c = MergeMaps(c, d)
+				}
+			}
+		}
+		if c, err = expandDeployTemplates(c, k, component); err != nil {
+			return nil, fmt.Errorf("deploy %s: %s", JoinPath(k, component), err)
+		}
+		merged[k] = c
+		orders[k] = order
+	}
+	return &mergedDeploys{component: component, config: merged, orders: orders}, nil
+}
+
+// newDeploys reads the merged config of md as Deploys
+func (s Svc) newDeploys(md *mergedDeploys) (Deploys, error) {
+	component, orders := md.component, md.orders
 
 	// marshal back to yaml
-	yml, err := yaml.Marshal(ds)
+	yml, err := yaml.Marshal(md.config)
 	if err != nil {
 		return nil, err
 	}
@@ -337,6 +761,10 @@ func (s Svc) buildDeploys(m map[string]interface{}, component string) (Deploys,
 		deploy := wrappedDeploys[env]
 		deploy.Environment = env
 		deploy.Component = component
+		deploy.MergeOrder = nil
+		if len(orders[env]) > 1 {
+			deploy.MergeOrder = orders[env]
+		}
 		if len(deploy.Chain) == 0 {
 			deploy.Chain = []string{"helm", "with", "namespace", "labels", "kustomize", "jsonnet"}
 		}
@@ -358,69 +786,60 @@ func (s Svc) buildDeploys(m map[string]interface{}, component string) (Deploys,
 	return deploys, nil
 }
 
-func componentName(p string) string {
-	parts := strings.Split(p, string(os.PathSeparator))
-	return strings.TrimSuffix(parts[len(parts)-1], Suffix)
-}
-
-func set(m interface{}, path []string, v interface{}) error {
-	l := len(path)
-	if l == 0 {
-		return errors.New("0 length path")
+// withoutKey returns a shallow copy of m without key k
+func withoutKey(m map[string]interface{}, k string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for kk, v := range m {
+		if kk != k {
+			out[kk] = v
+		}
 	}
-	p := path[0]
+	return out
+}
 
-	switch m := m.(type) {
-	case map[string]interface{}:
-		if l == 1 {
-			m[p] = v
-			return nil
-		}
-		if vv, ok := m[p]; !ok || vv == nil {
-			m[p] = setType(path[1])
+// extendsOrder follows the extends chain of the deploy for env and returns
+// the environments in the order they should be merged, ending with env.
+func extendsOrder(ds map[string]map[string]interface{}, env string) ([]string, error) {
+	var chain []string
+	seen := make(map[string]bool)
+	for e := env; e != ""; {
+		chain = append(chain, e)
+		if seen[e] {
+			return nil, fmt.Errorf("deploy %s extends cycle: %s", env, strings.Join(chain, " -> "))
 		}
-		if err := set(m[p], path[1:], v); err != nil {
-			return err
-		}
-	case []interface{}:
-		i, err := strconv.Atoi(p)
-		if err != nil {
-			return err
+		seen[e] = true
+		d, ok := ds[e]
+		if !ok {
+			return nil, fmt.Errorf("deploy %s extends unknown deploy %s", env, e)
 		}
-		if l == 1 {
-			m[i] = v
-			return nil
-		} else {
-			m[i] = setType(path[1])
+		next, ok := d["extends"].(string)
+		if !ok && d["extends"] != nil {
+			return nil, fmt.Errorf("deploy %s extends must be a string", e)
 		}
-		return set(m[i], path[1:], v)
-	default:
-		return errors.New("unhandled type")
+		e = next
+	}
+	order := make([]string, len(chain))
+	for i, e := range chain {
+		order[len(chain)-1-i] = e
 	}
-	return nil
+	return order, nil
 }
 
-func setType(p string) interface{} {
-	if pp, err := strconv.Atoi(p); err == nil {
-		// should be a list
-		if pp < 0 {
-			return errors.New("index less than 0")
-		}
-		return make([]interface{}, pp+1)
-	} else {
-		// or map
-		return make(map[string]interface{})
-	}
+// componentName returns the component name for a config file path relative
+// to the config directory
+func componentName(p string) string {
+	return filepath.ToSlash(strings.TrimSuffix(p, Suffix))
 }
 
 func (d Deploy) Id() string {
-	return fmt.Sprintf("%s.%s", d.Environment, d.Component)
+	return JoinPath(d.Environment, d.Component)
 }
 
-// DeployIdParts returns "environment.component" or error
+// DeployIdParts returns "environment.component" or error. An environment
+// or component containing dots is quoted, e.g. prod."my.app"
 func DeployIdParts(id string) (string, string, error) {
-	parts := strings.Split(id, ".")
-	if len(parts) != 2 {
+	parts, err := SplitPath(id)
+	if err != nil || len(parts) != 2 {
 		return "", "", fmt.Errorf("invalid %s", id)
 	}
 	return parts[0], parts[1], nil
@@ -1,6 +1,7 @@
 package cfg
 
 import (
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
@@ -9,6 +10,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestSvc_Init(t *testing.T) {
@@ -116,6 +118,135 @@ deploy:
 	)
 }
 
+func TestSvc_Deploys_environmentConfig(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	files := map[string]string{
+		"/test/simple-ops.yml": "labels:\n  a: global\n  b: global\n  c: global\n",
+		"/test/environments/prod.yml": `
+labels:
+  b: prod
+  c: prod
+values:
+  global:
+    imageRegistry: registry.prod
+`,
+		"/test/config/app.yml": `
+labels:
+  c: app
+deploy:
+  prod:
+    chart: app.tgz
+  staging:
+    chart: app.tgz
+`,
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(c.appFs, path, []byte(content), DefaultConfigFsPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	actual, err := c.Deploys()
+	assert.NilError(t, err)
+	chain := []string{"helm", "with", "namespace", "labels", "kustomize", "jsonnet"}
+	expected := Deploys{
+		{
+			Chart:       "app.tgz",
+			Environment: "prod",
+			Component:   "app",
+			Labels:      map[string]string{"a": "global", "b": "prod", "c": "app"},
+			Values:      map[string]interface{}{"global": map[string]interface{}{"imageRegistry": "registry.prod"}},
+			Chain:       chain,
+		},
+		{
+			Chart:       "app.tgz",
+			Environment: "staging",
+			Component:   "app",
+			Labels:      map[string]string{"a": "global", "b": "global", "c": "app"},
+			Chain:       chain,
+		},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
+
+func TestSvc_Deploys_nestedConfig(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	files := map[string]string{
+		"/test/simple-ops.yml":                 "labels:\n  a: global\n  b: global\n  c: global\n",
+		"/test/environments/prod.yml":          "labels:\n  d: prod\n",
+		"/test/config/_defaults.yml":           "labels:\n  b: config\n",
+		"/test/config/payments/_defaults.yml":  "labels:\n  c: payments\n  d: payments\ndeploy:\n  prod:\n    chart: payments.tgz\n",
+		"/test/config/payments/api.yml":        "labels:\n  d: api\n",
+		"/test/config/payments/worker/app.yml": "name: worker\ndeploy:\n  prod:\n    labels:\n      a: worker\n",
+		"/test/config/web.yml":                 "deploy:\n  prod:\n    chart: web.tgz\n",
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(c.appFs, path, []byte(content), DefaultConfigFsPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	actual, err := c.Deploys()
+	assert.NilError(t, err)
+	chain := []string{"helm", "with", "namespace", "labels", "kustomize", "jsonnet"}
+	expected := Deploys{
+		{
+			Chart:       "payments.tgz",
+			Environment: "prod",
+			Component:   "payments/api",
+			Labels:      map[string]string{"a": "global", "b": "config", "c": "payments", "d": "api"},
+			Chain:       chain,
+		},
+		{
+			Chart:       "web.tgz",
+			Environment: "prod",
+			Component:   "web",
+			Labels:      map[string]string{"a": "global", "b": "config", "c": "global", "d": "prod"},
+			Chain:       chain,
+		},
+		{
+			Chart:       "payments.tgz",
+			Environment: "prod",
+			Component:   "worker",
+			Labels:      map[string]string{"a": "worker", "b": "config", "c": "payments", "d": "payments"},
+			Chain:       chain,
+		},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
+
+func TestSvc_getConfigPaths_duplicateName(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	files := map[string]string{
+		"/test/config/a.yml":      "chart: a.tgz\n",
+		"/test/config/team/b.yml": "name: a\n",
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(c.appFs, path, []byte(content), DefaultConfigFsPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := c.getConfigPaths()
+	assert.Error(t, err, "component a is configured by both config/a.yml and config/team/b.yml")
+}
+
+func TestSvc_getConfigPaths_nestedName(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	files := map[string]string{
+		"/test/config/payments.yml":     "chart: a.tgz\n",
+		"/test/config/payments-web.yml": "chart: a.tgz\n",
+		"/test/config/payments/api.yml": "chart: a.tgz\n",
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(c.appFs, path, []byte(content), DefaultConfigFsPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := c.getConfigPaths()
+	assert.Error(t, err, "component payments/api of config/payments/api.yml is nested in component payments of config/payments.yml")
+	assert.NilError(t, c.appFs.Remove("/test/config/payments.yml"))
+	_, err = c.getConfigPaths()
+	assert.NilError(t, err)
+}
+
 func TestSvc_getConfigPaths(t *testing.T) {
 	var err error
 	var s = string(os.PathSeparator)
@@ -146,13 +277,23 @@ func TestSvc_parseConfig(t *testing.T) {
 	for _, tc := range []struct {
 		path    string
 		content string
+		version string
 		err     string
 		expect  map[string]interface{}
 	}{
 		// invalid content
-		{"a.yml", "\t", "error converting YAML to JSON: yaml: found character that cannot start any token", nil},
+		{"a.yml", "\t", APIVersion, "error converting YAML to JSON: yaml: found character that cannot start any token", nil},
 		// valid content
-		{"b.yaml", "a:\n  b: 1", "", map[string]interface{}{"a": map[string]interface{}{"b": float64(1)}}},
+		{"b.yaml", "a:\n  b: 1", APIVersion, "", map[string]interface{}{"a": map[string]interface{}{"b": float64(1)}}},
+		// legacy content is read as it always was
+		{"c.yml", "kustomizationPaths: [a]\ndeploys:\n  prod: {}\n", LegacyAPIVersion, "", map[string]interface{}{
+			"kustomizationPaths": []interface{}{"a"},
+			"deploys":            map[string]interface{}{"prod": map[string]interface{}{}},
+		}},
+		// the apiVersion key is not config
+		{"d.yml", "apiVersion: simple-ops/v1\nchart: a.tgz\n", LegacyAPIVersion, "", map[string]interface{}{"chart": "a.tgz"}},
+		// unsupported apiVersion
+		{"e.yml", "apiVersion: simple-ops/v9\n", APIVersion, "e.yml: unsupported apiVersion simple-ops/v9, the newest supported is simple-ops/v1", nil},
 	} {
 		c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
 		if err := c.appFs.Mkdir(ConfPath, DefaultConfigFsPerm); err != nil {
@@ -161,7 +302,7 @@ func TestSvc_parseConfig(t *testing.T) {
 		if err := afero.WriteFile(c.appFs, filepath.Join(c.wd, tc.path), []byte(tc.content), DefaultConfigFsPerm); err != nil {
 			t.Fatal(err)
 		}
-		actual, err := c.parseConfig(tc.path)
+		actual, err := c.parseConfig(tc.path, tc.version)
 		if tc.err != "" {
 			if err == nil {
 				t.Fatal("expected error")
@@ -192,7 +333,7 @@ func TestSvc_buildDeploys(t *testing.T) {
 		},
 	}
 	component := "test"
-	actual, err := c.buildDeploys(m, component)
+	actual, err := c.buildDeploys(nil, nil, nil, m, component)
 	if err != nil {
 		t.Error(err)
 	}
@@ -218,7 +359,7 @@ func TestSvc_buildDeploys_without_values(t *testing.T) {
 		},
 	}
 	component := "test"
-	actual, err := c.buildDeploys(m, component)
+	actual, err := c.buildDeploys(nil, nil, nil, m, component)
 	if err != nil {
 		t.Error(err)
 	}
@@ -233,6 +374,318 @@ func TestSvc_buildDeploys_without_values(t *testing.T) {
 	assert.DeepEqual(t, expected, actual)
 }
 
+func TestSvc_buildDeploys_extends(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+
+	m := map[string]interface{}{
+		"chart": "a.tgz",
+		"values": map[string]interface{}{
+			"replicas": 1,
+		},
+		"deploy": map[string]interface{}{
+			"prod": map[string]interface{}{
+				"values": map[string]interface{}{"replicas": 3, "region": "default"},
+			},
+			"prod-eu": map[string]interface{}{
+				"extends": "prod",
+				"values":  map[string]interface{}{"region": "eu"},
+			},
+			"prod-eu-2": map[string]interface{}{
+				"extends": "prod-eu",
+				"chart":   "b.tgz",
+			},
+		},
+	}
+	actual, err := c.buildDeploys(nil, nil, nil, m, "test")
+	assert.NilError(t, err)
+	chain := []string{"helm", "with", "namespace", "labels", "kustomize", "jsonnet"}
+	expected := Deploys{
+		&Deploy{
+			Chart:       "a.tgz",
+			Values:      map[string]interface{}{"replicas": float64(3), "region": "default"},
+			Component:   "test",
+			Environment: "prod",
+			Chain:       chain,
+		},
+		&Deploy{
+			Chart:       "a.tgz",
+			Values:      map[string]interface{}{"replicas": float64(3), "region": "eu"},
+			Component:   "test",
+			Environment: "prod-eu",
+			Chain:       chain,
+			Extends:     "prod",
+			MergeOrder:  []string{"prod", "prod-eu"},
+		},
+		&Deploy{
+			Chart:       "b.tgz",
+			Values:      map[string]interface{}{"replicas": float64(3), "region": "eu"},
+			Component:   "test",
+			Environment: "prod-eu-2",
+			Chain:       chain,
+			Extends:     "prod-eu",
+			MergeOrder:  []string{"prod", "prod-eu", "prod-eu-2"},
+		},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
+
+func TestSvc_buildDeploys_mergeDirectives(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	global := map[string]interface{}{
+		"kustomizationPaths": []interface{}{"global"},
+	}
+	m := map[string]interface{}{
+		"kustomizationPaths$append": []interface{}{"component"},
+		"values": map[string]interface{}{
+			"tolerations": []interface{}{
+				map[string]interface{}{"name": "a", "effect": "NoSchedule"},
+			},
+		},
+		"deploy": map[string]interface{}{
+			"prod": map[string]interface{}{
+				"kustomizationPaths$prepend": []interface{}{"prod"},
+				"chain$replace":              []interface{}{"helm"},
+				"values": map[string]interface{}{
+					"tolerations$merge": []interface{}{
+						map[string]interface{}{"name": "a", "effect": "NoExecute"},
+						map[string]interface{}{"name": "b"},
+					},
+				},
+			},
+		},
+	}
+	actual, err := c.buildDeploys(global, nil, nil, m, "test")
+	assert.NilError(t, err)
+	expected := Deploys{
+		&Deploy{
+			KustomizationPaths: []string{"prod", "global", "component"},
+			Values: map[string]interface{}{"tolerations": []interface{}{
+				map[string]interface{}{"name": "a", "effect": "NoExecute"},
+				map[string]interface{}{"name": "b"},
+			}},
+			Component:   "test",
+			Environment: "prod",
+			Chain:       []string{"helm"},
+		},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
+
+func TestSvc_buildDeploys_delete(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	global := map[string]interface{}{
+		"labels": map[string]interface{}{"team": "a", "tier": "b"},
+	}
+	m := map[string]interface{}{
+		"values": map[string]interface{}{"resources": map[string]interface{}{"limits": "x"}, "replicas": 2},
+		"with": map[string]interface{}{
+			"application": map[string]interface{}{"argocd": map[string]interface{}{}},
+		},
+		"deploy": map[string]interface{}{
+			"dev": map[string]interface{}{
+				"labels": map[string]interface{}{"tier": "$delete"},
+				"values": map[string]interface{}{"resources": "$delete"},
+				"with":   "$delete",
+			},
+		},
+	}
+	actual, err := c.buildDeploys(global, nil, nil, m, "test")
+	assert.NilError(t, err)
+	expected := Deploys{
+		&Deploy{
+			Labels:      map[string]string{"team": "a"},
+			Values:      map[string]interface{}{"replicas": float64(2)},
+			Component:   "test",
+			Environment: "dev",
+			Chain:       []string{"helm", "with", "namespace", "labels", "kustomize", "jsonnet"},
+		},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
+
+func TestSvc_buildDeploys_deleteInListItems(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	m := map[string]interface{}{
+		"values": map[string]interface{}{
+			"tolerations": []interface{}{
+				map[string]interface{}{"name": "spot", "effect": "NoExecute"},
+			},
+		},
+		"deploy": map[string]interface{}{
+			"dev": map[string]interface{}{
+				"values": map[string]interface{}{
+					"tolerations": []interface{}{
+						map[string]interface{}{"name": "spot", "effect": "$delete"},
+					},
+					"affinity$append": []interface{}{
+						map[string]interface{}{"key": "a", "operator": "$delete"},
+					},
+				},
+			},
+			"prod": map[string]interface{}{
+				"values": map[string]interface{}{
+					"tolerations$merge": []interface{}{
+						map[string]interface{}{"name": "spot", "effect": "$delete"},
+						map[string]interface{}{"name": "arm", "effect": "$delete", "value": "x"},
+					},
+				},
+			},
+		},
+	}
+	actual, err := c.buildDeploys(nil, nil, nil, m, "test")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, actual[0].Values, map[string]interface{}{
+		"tolerations": []interface{}{map[string]interface{}{"name": "spot"}},
+		"affinity":    []interface{}{map[string]interface{}{"key": "a"}},
+	})
+	assert.DeepEqual(t, actual[1].Values, map[string]interface{}{
+		"tolerations": []interface{}{
+			map[string]interface{}{"name": "spot"},
+			map[string]interface{}{"name": "arm", "value": "x"},
+		},
+	})
+}
+
+func TestSvc_buildDeploys_matrix(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	m := map[string]interface{}{
+		"chart": "a.tgz",
+		"matrix": map[string]interface{}{
+			"params": map[string]interface{}{
+				"prod-eu": map[string]interface{}{"region": "eu-west-1", "cluster": "eu1"},
+				"prod-us": map[string]interface{}{"region": "us-east-1", "cluster": "us1"},
+			},
+			"deploy": map[string]interface{}{
+				"values": map[string]interface{}{
+					"region":  "${{ .Params.region }}",
+					"cluster": "k8s-${{ .Params.cluster }}",
+				},
+			},
+		},
+		"deploy": map[string]interface{}{
+			"prod-us": map[string]interface{}{
+				"values": map[string]interface{}{"replicas": 3},
+			},
+		},
+	}
+	actual, err := c.buildDeploys(nil, nil, nil, m, "test")
+	assert.NilError(t, err)
+	chain := []string{"helm", "with", "namespace", "labels", "kustomize", "jsonnet"}
+	expected := Deploys{
+		&Deploy{
+			Chart:       "a.tgz",
+			Values:      map[string]interface{}{"region": "eu-west-1", "cluster": "k8s-eu1"},
+			Params:      map[string]interface{}{"region": "eu-west-1", "cluster": "eu1"},
+			Component:   "test",
+			Environment: "prod-eu",
+			Chain:       chain,
+		},
+		&Deploy{
+			Chart:       "a.tgz",
+			Values:      map[string]interface{}{"region": "us-east-1", "cluster": "k8s-us1", "replicas": float64(3)},
+			Params:      map[string]interface{}{"region": "us-east-1", "cluster": "us1"},
+			Component:   "test",
+			Environment: "prod-us",
+			Chain:       chain,
+		},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
+
+func TestSvc_buildDeploys_templates(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	global := map[string]interface{}{
+		"namespace": map[string]interface{}{"name": "${{ .Environment }}-${{ .Component }}"},
+		"labels":    map[string]interface{}{"team": "payments"},
+	}
+	m := map[string]interface{}{
+		"values": map[string]interface{}{
+			"image":     map[string]interface{}{"tag": "v1"},
+			"namespace": "${{ .Namespace.Name }}",
+			"hosts":     []interface{}{"${{ .Component }}.${{ .Environment }}.example.com"},
+			"team":      "${{ .Labels.team }}",
+			"tag":       `${{ index .Values "image" "tag" }}`,
+			"helm":      "{{ .Release.Name }}",
+		},
+		"with": map[string]interface{}{
+			"application": map[string]interface{}{
+				"app": map[string]interface{}{"path": "apps/${{ .Environment }}/${{ .Component }}.yaml"},
+			},
+		},
+		"deploy": map[string]interface{}{
+			"prod": map[string]interface{}{},
+		},
+	}
+	actual, err := c.buildDeploys(global, nil, nil, m, "api")
+	assert.NilError(t, err)
+	assert.Equal(t, actual[0].Namespace.Name, "prod-api")
+	assert.DeepEqual(t, actual[0].Values, map[string]interface{}{
+		"image":     map[string]interface{}{"tag": "v1"},
+		"namespace": "prod-api",
+		"hosts":     []interface{}{"api.prod.example.com"},
+		"team":      "payments",
+		"tag":       "v1",
+		"helm":      "{{ .Release.Name }}",
+	})
+	assert.Equal(t, actual[0].With["application"]["app"].Path, "apps/prod/api.yaml")
+}
+
+func TestSvc_buildDeploys_matrixErrors(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	for _, tc := range []struct {
+		matrix interface{}
+		err    string
+	}{
+		{matrix: "a", err: "component test: matrix must be a map"},
+		{matrix: map[string]interface{}{}, err: "component test: matrix params must be a map of environment to params"},
+		{
+			matrix: map[string]interface{}{"params": map[string]interface{}{"prod": "a"}},
+			err:    "component test: matrix params prod must be a map",
+		},
+		{
+			matrix: map[string]interface{}{
+				"params": map[string]interface{}{"prod": map[string]interface{}{}},
+				"deploy": map[string]interface{}{"values": map[string]interface{}{"a": "${{ .Params.region }}"}},
+			},
+			err: `deploy prod.test: values.a: template: value:1:11: executing "value" at <.Params.region>: map has no entry for key "region"`,
+		},
+	} {
+		_, err := c.buildDeploys(nil, nil, nil, map[string]interface{}{"matrix": tc.matrix}, "test")
+		assert.Error(t, err, tc.err)
+	}
+}
+
+func TestSvc_buildDeploys_extendsErrors(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	for _, tc := range []struct {
+		deploy map[string]interface{}
+		err    string
+	}{
+		{
+			map[string]interface{}{
+				"a": map[string]interface{}{"extends": "b"},
+				"b": map[string]interface{}{"extends": "a"},
+			},
+			"deploy a extends cycle: a -> b -> a",
+		},
+		{
+			map[string]interface{}{
+				"a": map[string]interface{}{"extends": "a"},
+			},
+			"deploy a extends cycle: a -> a",
+		},
+		{
+			map[string]interface{}{
+				"a": map[string]interface{}{"extends": "c"},
+			},
+			"deploy a extends unknown deploy c",
+		},
+	} {
+		_, err := c.buildDeploys(nil, nil, nil, map[string]interface{}{"deploy": tc.deploy}, "test")
+		assert.Error(t, err, tc.err)
+	}
+}
+
 func setupSetTest(t *testing.T, configFile string, configBytes []byte) *Svc {
 	var err error
 	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
@@ -301,6 +754,362 @@ func TestSvc_SetNewListMapString(t *testing.T) {
 	assert.Equal(t, expected, string(actual))
 }
 
+func TestSvc_SetAppendList(t *testing.T) {
+	conf := []byte("chart: a.yml\ndeploy:\n  example:\n    values:\n      hosts:\n      - a\n")
+	c := setupSetTest(t, "/test/config/a.yml", conf)
+	assert.NilError(t, c.Set("a.deploy.example.values.hosts.+", "b"))
+	assert.NilError(t, c.Set("a.deploy.example.values.tls.+.secretName", "c"))
+	assert.NilError(t, c.Set("a.deploy.example.values.hosts.2", "d"))
+	expected := "chart: a.yml\ndeploy:\n  example:\n    values:\n      hosts:\n      - a\n      - b\n      - d\n      tls:\n      - secretName: c\n"
+	actual, err := c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, expected, string(actual))
+}
+
+func TestSvc_SetListIndexOutOfRange(t *testing.T) {
+	c := setupSetTest(t, "/test/config/a.yml", []byte("values:\n  hosts:\n  - a\n"))
+	err := c.Set("a.values.hosts.2", "b")
+	assert.Error(t, err, "a.values.hosts.2: index 2 out of range for list of length 1")
+}
+
+func TestSvc_SetValueTypes(t *testing.T) {
+	c := setupSetTest(t, "/test/config/a.yml", []byte("values:\n  a: 1\n"))
+	assert.NilError(t, c.Set("a.values.a", nil))
+	assert.NilError(t, c.Set("a.values.b", 1.5))
+	assert.NilError(t, c.Set("a.values.c", []interface{}{"x", map[string]interface{}{"z": 1}}))
+	expected := "values:\n  a: null\n  b: 1.5\n  c:\n  - x\n  - z: 1\n"
+	actual, err := c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, expected, string(actual))
+}
+
+func TestSvc_Unset(t *testing.T) {
+	conf := "chart: a.tgz\ndeploy:\n  example:\n    values:\n      image:\n        tag: v1\n    with:\n      a:\n        b: {}\n        c: {}\n"
+	for _, tc := range []struct {
+		path     string
+		expected string
+	}{
+		// removes maps left empty
+		{"a.deploy.example.values.image.tag", "chart: a.tgz\ndeploy:\n  example:\n    with:\n      a:\n        b: {}\n        c: {}\n"},
+		{"a.deploy.example.with.a.b", "chart: a.tgz\ndeploy:\n  example:\n    values:\n      image:\n        tag: v1\n    with:\n      a:\n        c: {}\n"},
+		{"a.deploy", "chart: a.tgz\n"},
+	} {
+		c := setupSetTest(t, "/test/config/a.yml", []byte(conf))
+		assert.NilError(t, c.Unset(tc.path))
+		actual, err := c.appFs.ReadFile("/test/config/a.yml")
+		assert.NilError(t, err)
+		assert.Equal(t, tc.expected, string(actual))
+	}
+}
+
+func TestSvc_SetThroughAlias(t *testing.T) {
+	conf := "values:\n  ref: &r\n    k: v\n  use: *r\n"
+	for _, tc := range []struct {
+		path     string
+		expected string
+	}{
+		{"a.values.use.k", "values:\n  ref: &r\n    k: v\n  use:\n    k: z\n"},
+		{"a.values.use", "values:\n  ref: &r\n    k: v\n  use: z\n"},
+		{"a.values.ref.k", "values:\n  ref: &r\n    k: z\n  use: *r\n"},
+	} {
+		c := setupSetTest(t, "/test/config/a.yml", []byte(conf))
+		assert.NilError(t, c.Set(tc.path, "z"))
+		actual, err := c.appFs.ReadFile("/test/config/a.yml")
+		assert.NilError(t, err)
+		assert.Equal(t, tc.expected, string(actual))
+	}
+}
+
+func TestSvc_UnsetThroughAlias(t *testing.T) {
+	conf := "values:\n  ref: &r\n    k: v\n    l: w\n  use: *r\n"
+	for _, tc := range []struct {
+		path     string
+		expected string
+	}{
+		{"a.values.use.k", "values:\n  ref: &r\n    k: v\n    l: w\n  use:\n    l: w\n"},
+		{"a.values.use", "values:\n  ref: &r\n    k: v\n    l: w\n"},
+	} {
+		c := setupSetTest(t, "/test/config/a.yml", []byte(conf))
+		assert.NilError(t, c.Unset(tc.path))
+		actual, err := c.appFs.ReadFile("/test/config/a.yml")
+		assert.NilError(t, err)
+		assert.Equal(t, tc.expected, string(actual))
+	}
+}
+
+func TestSvc_UnsetListElement(t *testing.T) {
+	c := setupSetTest(t, "/test/config/a.yml", []byte("kustomizationPaths:\n- a\n- b\n- c\nvalues:\n  hosts:\n  - name: a\n"))
+	assert.NilError(t, c.Unset("a.kustomizationPaths.1"))
+	assert.NilError(t, c.Unset("a.values.hosts.0.name"))
+	actual, err := c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, "kustomizationPaths:\n- a\n- c\nvalues:\n  hosts: []\n", string(actual))
+}
+
+func TestSvc_UnsetRemovesEmptyFile(t *testing.T) {
+	c := setupSetTest(t, "/test/config/a.yml", []byte("chart: a.tgz\n"))
+	assert.NilError(t, c.Unset("a.chart"))
+	_, err := c.appFs.Stat("/test/config/a.yml")
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestSvc_UnsetNotFound(t *testing.T) {
+	c := setupSetTest(t, "/test/config/a.yml", []byte("chart: a.tgz\nkustomizationPaths:\n- a\n"))
+	for _, path := range []string{"a.values", "a.chart.name", "a.kustomizationPaths.1", "a.kustomizationPaths.x", "b.chart"} {
+		assert.Error(t, c.Unset(path), fmt.Sprintf("path %s not found", path))
+	}
+	assert.Error(t, c.Unset("a"), "invalid path a")
+}
+
+func TestSvc_SetDottedKeys(t *testing.T) {
+	c := setupSetTest(t, "/test/config/my.app.yml", []byte("labels:\n"))
+	assert.NilError(t, c.Set(`"my.app".labels."app.kubernetes.io/part-of"`, "platform"))
+	assert.NilError(t, c.Set(`"my.app".values.podAnnotations.prometheus\.io/scrape`, "true"))
+	actual, err := c.appFs.ReadFile("/test/config/my.app.yml")
+	assert.NilError(t, err)
+	expected := "labels:\n  app.kubernetes.io/part-of: platform\nvalues:\n  podAnnotations:\n    prometheus.io/scrape: \"true\"\n"
+	assert.Equal(t, expected, string(actual))
+	assert.NilError(t, c.Unset(`"my.app".labels."app.kubernetes.io/part-of"`))
+}
+
+func TestSvc_SetNestedComponent(t *testing.T) {
+	c := setupSetTest(t, "/test/config/team/app.yml", []byte("name: app\nchart: a.tgz\n"))
+	assert.NilError(t, c.Set("app.deploy.prod.chart", "b.tgz"))
+	assert.NilError(t, c.Set("team/new.chart", "c.tgz"))
+	actual, err := c.appFs.ReadFile("/test/config/team/app.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, "name: app\nchart: a.tgz\ndeploy:\n  prod:\n    chart: b.tgz\n", string(actual))
+	actual, err = c.appFs.ReadFile("/test/config/team/new.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, "chart: c.tgz\n", string(actual))
+}
+
+func TestSvc_SetBatch(t *testing.T) {
+	c := setupSetTest(t, "/test/config/a.yml", []byte("chart: a.tgz\n"))
+	err := c.SetBatch([]Edit{
+		{Path: "a.deploy.prod.values.image.tag", Value: "v2"},
+		{Path: "a.deploy.prod.values.replicas", Value: 2},
+		{Path: "b.chart", Value: "b.tgz"},
+	})
+	assert.NilError(t, err)
+	actual, err := c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, "chart: a.tgz\ndeploy:\n  prod:\n    values:\n      image:\n        tag: v2\n      replicas: 2\n", string(actual))
+	actual, err = c.appFs.ReadFile("/test/config/b.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, "chart: b.tgz\n", string(actual))
+}
+
+func TestSvc_SetBatch_NothingChangedOnError(t *testing.T) {
+	c := setupSetTest(t, "/test/config/a.yml", []byte("chart: a.tgz\n"))
+	err := c.SetBatch([]Edit{
+		{Path: "a.deploy.prod.values.image.tag", Value: "v2"},
+		{Path: "b.chart", Value: "b.tgz"},
+		{Path: "a.chart.name", Value: "a"},
+	})
+	assert.Error(t, err, "a.chart.name: cannot set name in a scalar value")
+	actual, err := c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, "chart: a.tgz\n", string(actual))
+	_, err = c.appFs.Stat("/test/config/b.yml")
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestSvc_SetBatch_Preconditions(t *testing.T) {
+	c := setupSetTest(t, "/test/config/a.yml", []byte("chart: a.tgz\nnamespace: a\n"))
+	expect := func(v string) *string { return &v }
+	tcs := []struct {
+		edit Edit
+		err  string
+	}{
+		{edit: Edit{Path: "a.chart", Value: "b.tgz", Expect: expect("c.tgz")}, err: `precondition failed: a.chart does not have the expected value "c.tgz"`},
+		{edit: Edit{Path: "a.version", Value: "1", Expect: expect("")}, err: `precondition failed: a.version has no value, expected ""`},
+		{edit: Edit{Path: "a.namespace", Value: "b", IfAbsent: true}, err: "precondition failed: a.namespace already has a value"},
+		{edit: Edit{Path: "a.chart", Value: "b.tgz", Expect: expect("a.tgz")}},
+		{edit: Edit{Path: "a.version", Value: "1", IfAbsent: true}},
+	}
+	for _, tc := range tcs {
+		err := c.SetBatch([]Edit{tc.edit})
+		if tc.err == "" {
+			assert.NilError(t, err)
+			continue
+		}
+		assert.Error(t, err, tc.err)
+		assert.Assert(t, errors.Is(err, ErrPreconditionFailed))
+	}
+	actual, err := c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, "chart: b.tgz\nnamespace: a\nversion: \"1\"\n", string(actual))
+}
+
+func TestSvc_lockConfig(t *testing.T) {
+	wd := t.TempDir()
+	assert.NilError(t, os.Mkdir(filepath.Join(wd, ConfPath), 0755))
+	c := NewSvc(afero.NewOsFs(), wd, logrus.New())
+	unlock, err := c.lockConfig()
+	assert.NilError(t, err)
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := c.lockConfig()
+		assert.Check(t, err)
+		close(locked)
+		unlock()
+	}()
+	select {
+	case <-locked:
+		t.Fatal("expected second lock to wait for the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+}
+
+func TestSvc_SetBatch_newConfigDir(t *testing.T) {
+	wd := t.TempDir()
+	c := NewSvc(afero.NewOsFs(), wd, logrus.New())
+	assert.NilError(t, c.SetBatch([]Edit{
+		{Path: "a.chart", Value: "a.tgz"},
+		{Path: "team/b.chart", Value: "b.tgz"},
+	}))
+	actual, err := os.ReadFile(filepath.Join(wd, ConfPath, "a.yml"))
+	assert.NilError(t, err)
+	assert.Equal(t, "chart: a.tgz\n", string(actual))
+	actual, err = os.ReadFile(filepath.Join(wd, ConfPath, "team", "b.yml"))
+	assert.NilError(t, err)
+	assert.Equal(t, "chart: b.tgz\n", string(actual))
+}
+
+func TestSvc_SetPreservesFormatting(t *testing.T) {
+	conf := `# the a component
+chart: a.tgz # pinned
+deploy:
+  # production
+  prod:
+    values:
+      zeta: 1
+      image:
+        # bumped by CI
+        tag: "v1" # current tag
+      alpha:
+        - "x"
+        - 'y'
+`
+	c := setupSetTest(t, "/test/config/a.yml", []byte(conf))
+	assert.NilError(t, c.Set("a.deploy.prod.values.image.tag", "v2"))
+	assert.NilError(t, c.Set("a.deploy.prod.values.alpha.1", "z"))
+	assert.NilError(t, c.Set("a.deploy.prod.values.beta", "b"))
+	expected := `# the a component
+chart: a.tgz # pinned
+deploy:
+  # production
+  prod:
+    values:
+      zeta: 1
+      image:
+        # bumped by CI
+        tag: "v2" # current tag
+      alpha:
+        - "x"
+        - 'z'
+      beta: b
+`
+	actual, err := c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, expected, string(actual))
+
+	// blank lines and the indentation of the file are kept
+	conf = `chart: a.tgz
+
+values:
+    image: x
+    args:
+    - --a
+
+deploy:
+    prod:
+        values:
+            image: y
+`
+	c = setupSetTest(t, "/test/config/a.yml", []byte(conf))
+	assert.NilError(t, c.Set("a.values.image", "z"))
+	assert.NilError(t, c.Set("a.values.args.+", "--b"))
+	assert.NilError(t, c.Set("a.values.pull", "always"))
+	assert.NilError(t, c.Set("a.deploy.prod.values.tag", "v1"))
+	assert.NilError(t, c.Set("a.deploy.staging.values.image", "s"))
+	expected = `chart: a.tgz
+
+values:
+    image: z
+    args:
+    - --a
+    - --b
+    pull: always
+
+deploy:
+    prod:
+        values:
+            image: y
+            tag: v1
+    staging:
+        values:
+            image: s
+`
+	actual, err = c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, expected, string(actual))
+
+	// as they are when nothing changes
+	assert.NilError(t, c.Set("a.values.image", "z"))
+	actual, err = c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, expected, string(actual))
+
+	// lines the encoder writes differently but that are not edited are kept
+	conf = `base: &b
+  k: v
+values:
+  <<: *b
+  a: 1   # trailing
+  b:   x
+  tag: v1
+`
+	c = setupSetTest(t, "/test/config/a.yml", []byte(conf))
+	assert.NilError(t, c.Set("a.values.tag", "v2"))
+	assert.NilError(t, c.Set("a.values.c", "z"))
+	expected = `base: &b
+  k: v
+values:
+  <<: *b
+  a: 1   # trailing
+  b:   x
+  tag: v2
+  c: z
+`
+	actual, err = c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, expected, string(actual))
+}
+
+func TestSvc_UnsetPreservesFormatting(t *testing.T) {
+	conf := "# the a component\ndeploy:\n  prod:\n    values:\n      b: 1 # b\n      a: 2\n"
+	c := setupSetTest(t, "/test/config/a.yml", []byte(conf))
+	assert.NilError(t, c.Unset("a.deploy.prod.values.a"))
+	actual, err := c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, "# the a component\ndeploy:\n  prod:\n    values:\n      b: 1 # b\n", string(actual))
+
+	conf = "chart: a.tgz\n\nvalues:\n    a: 1\n\nlabels:\n    b: c\n"
+	c = setupSetTest(t, "/test/config/a.yml", []byte(conf))
+	assert.NilError(t, c.Unset("a.values"))
+	actual, err = c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, "chart: a.tgz\n\nlabels:\n    b: c\n", string(actual))
+	assert.NilError(t, c.Unset("a.labels"))
+	actual, err = c.appFs.ReadFile("/test/config/a.yml")
+	assert.NilError(t, err)
+	assert.Equal(t, "chart: a.tgz\n", string(actual))
+}
+
 func TestSvc_GetDeploy_None(t *testing.T) {
 	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
 	if err := c.appFs.Mkdir("/test/config", DefaultConfigFsPerm); err != nil {
@@ -416,7 +1225,7 @@ func Test_componentName(t *testing.T) {
 		want string
 	}{
 		{"a.yml", args{p: "a.yml"}, "a"},
-		{"/path/test.yml", args{p: "/path/test.yml"}, "test"},
+		{"team/test.yml", args{p: "team/test.yml"}, "team/test"},
 		{".yml", args{p: ".yml"}, ""},
 		{".yaml", args{p: ".yaml"}, ".yaml"},
 	}
@@ -435,6 +1244,14 @@ func Test_DeployIdParts(t *testing.T) {
 	assert.Equal(t, e, "a")
 	assert.Equal(t, c, "b")
 }
+func Test_DeployIdParts_Quoted(t *testing.T) {
+	e, c, err := DeployIdParts(`"prod.eu"."my.app"`)
+	assert.NilError(t, err)
+	assert.Equal(t, e, "prod.eu")
+	assert.Equal(t, c, "my.app")
+	assert.Equal(t, Deploy{Environment: e, Component: c}.Id(), `"prod.eu"."my.app"`)
+}
+
 func Test_DeployIdParts_Invalid(t *testing.T) {
 	e, c, err := DeployIdParts("a.b.c")
 	assert.ErrorContains(t, err, "invalid a.b.c")
@@ -477,6 +1294,58 @@ func Test_MergeMaps(t *testing.T) {
 			b: map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"d": "test"}}},
 			e: map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": "test", "d": "test"}}},
 		},
+		{
+			// lists can be appended to
+			a: map[string]interface{}{"a": []interface{}{"1", "2"}},
+			b: map[string]interface{}{"a$append": []interface{}{"3"}},
+			e: map[string]interface{}{"a": []interface{}{"1", "2", "3"}},
+		},
+		{
+			// lists can be prepended to, after being set at the same level
+			a: map[string]interface{}{},
+			b: map[string]interface{}{"a": []interface{}{"2"}, "a$prepend": []interface{}{"1"}},
+			e: map[string]interface{}{"a": []interface{}{"1", "2"}},
+		},
+		{
+			// directives in the first map are resolved
+			a: map[string]interface{}{"a": map[string]interface{}{"b$append": []interface{}{"1"}}},
+			b: map[string]interface{}{"a": map[string]interface{}{"b$append": []interface{}{"2"}}},
+			e: map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{"1", "2"}}},
+		},
+		{
+			// maps can be replaced rather than merged
+			a: map[string]interface{}{"a": map[string]interface{}{"b": "c"}},
+			b: map[string]interface{}{"a$replace": map[string]interface{}{"d": "e"}},
+			e: map[string]interface{}{"a": map[string]interface{}{"d": "e"}},
+		},
+		{
+			// list items are merged by name
+			a: map[string]interface{}{"a": []interface{}{
+				map[string]interface{}{"name": "x", "v": 1},
+				map[string]interface{}{"name": "y", "v": 1},
+			}},
+			b: map[string]interface{}{"a$merge": []interface{}{
+				map[string]interface{}{"name": "y", "v": 2, "w": 2},
+				map[string]interface{}{"name": "z", "v": 2},
+			}},
+			e: map[string]interface{}{"a": []interface{}{
+				map[string]interface{}{"name": "x", "v": 1},
+				map[string]interface{}{"name": "y", "v": 2, "w": 2},
+				map[string]interface{}{"name": "z", "v": 2},
+			}},
+		},
+		{
+			// keys can be deleted
+			a: map[string]interface{}{"a": map[string]interface{}{"b": "c", "d": "e"}, "f": []interface{}{"g"}},
+			b: map[string]interface{}{"a": map[string]interface{}{"b": "$delete"}, "f$append": "$delete", "h": "$delete"},
+			e: map[string]interface{}{"a": map[string]interface{}{"d": "e"}},
+		},
+		{
+			// unknown directives are keys
+			a: map[string]interface{}{"a": "b"},
+			b: map[string]interface{}{"a$b": "c"},
+			e: map[string]interface{}{"a": "b", "a$b": "c"},
+		},
 	} {
 		assert.DeepEqual(t, MergeMaps(tc.a, tc.b), tc.e)
 	}
@@ -0,0 +1,371 @@
+package cfg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"strconv"
+	"strings"
+)
+
+// configDocument is a config file parsed as a yaml node tree such that
+// edits preserve comments, key order, quoting style and formatting.
+type configDocument struct {
+	path      string
+	doc       *kyaml.Node
+	seqIndent kyaml.SequenceIndentStyle
+	indent    int
+	docStart  bool
+	orig      []byte
+	exists    bool
+}
+
+// readConfigDocument parses the config file at path, a missing file
+// is treated as an empty document.
+func (s Svc) readConfigDocument(path string) (*configDocument, error) {
+	b, err := s.appFs.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	d := &configDocument{
+		path:      path,
+		doc:       &kyaml.Node{},
+		seqIndent: kyaml.SequenceIndentStyle(kyaml.DeriveSeqIndentStyle(string(b))),
+		indent:    detectIndent(b),
+		docStart:  bytes.HasPrefix(b, []byte("---")),
+		orig:      b,
+		exists:    err == nil,
+	}
+	if err := kyaml.Unmarshal(b, d.doc); err != nil {
+		return nil, err
+	}
+	if d.doc.Kind == 0 {
+		d.doc = &kyaml.Node{Kind: kyaml.DocumentNode}
+	}
+	if len(d.doc.Content) == 0 {
+		d.doc.Content = []*kyaml.Node{newMappingNode()}
+	}
+	return d, nil
+}
+
+// writeConfigDocument writes d to its path
+func (s Svc) writeConfigDocument(d *configDocument) error {
+	b, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	if err := s.appFs.MkdirAll(filepath.Dir(d.path), DefaultConfigDirPerm); err != nil {
+		return err
+	}
+	return s.appFs.WriteFile(d.path, b, DefaultConfigFsPerm)
+}
+
+// writeConfigDocuments writes every document or none of them. Content is
+// encoded before anything is written and files already written are
+// restored if a later write fails.
+func (s Svc) writeConfigDocuments(docs []*configDocument) (err error) {
+	content := make([][]byte, len(docs))
+	for i, d := range docs {
+		if content[i], err = d.bytes(); err != nil {
+			return err
+		}
+	}
+	for i, d := range docs {
+		if err = s.appFs.MkdirAll(filepath.Dir(d.path), DefaultConfigDirPerm); err == nil {
+			err = s.appFs.WriteFile(d.path, content[i], DefaultConfigFsPerm)
+		}
+		if err != nil {
+			for _, w := range docs[:i] {
+				if rerr := s.restoreConfigDocument(w); rerr != nil {
+					return fmt.Errorf("%s & restoring %s: %s", err, w.path, rerr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreConfigDocument restores the file d was read from
+func (s Svc) restoreConfigDocument(d *configDocument) error {
+	if !d.exists {
+		return s.appFs.Remove(d.path)
+	}
+	return s.appFs.WriteFile(d.path, d.orig, DefaultConfigFsPerm)
+}
+
+func (d *configDocument) root() *kyaml.Node {
+	return d.doc.Content[0]
+}
+
+func (d *configDocument) bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if d.docStart {
+		buf.WriteString("---\n")
+	}
+	enc := kyaml.NewEncoderWithOptions(&buf, &kyaml.EncoderOptions{SeqIndent: d.seqIndent})
+	if d.indent > 0 {
+		enc.SetIndent(d.indent)
+	}
+	if err := enc.Encode(d.doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	if !d.exists {
+		return buf.Bytes(), nil
+	}
+	// the encoder drops blank lines and normalises indentation, which are
+	// restored from the original file
+	return restoreFormatting(d.orig, buf.Bytes()), nil
+}
+
+// isEmpty is true when nothing but comments remain in the document
+func (d *configDocument) isEmpty() bool {
+	r := d.root()
+	return (r.Kind == kyaml.MappingNode || r.Kind == kyaml.SequenceNode) && len(r.Content) == 0
+}
+
+func newMappingNode() *kyaml.Node {
+	return &kyaml.Node{Kind: kyaml.MappingNode, Tag: "!!map"}
+}
+
+func newSequenceNode() *kyaml.Node {
+	return &kyaml.Node{Kind: kyaml.SequenceNode, Tag: "!!seq"}
+}
+
+func newNullNode() *kyaml.Node {
+	return &kyaml.Node{Kind: kyaml.ScalarNode, Tag: "!!null", Value: "null"}
+}
+
+func isNullNode(n *kyaml.Node) bool {
+	return n == nil || (n.Kind == kyaml.ScalarNode && n.ShortTag() == "!!null")
+}
+
+// valueNode encodes v as a yaml node
+func valueNode(v interface{}) (*kyaml.Node, error) {
+	n := &kyaml.Node{}
+	if err := n.Encode(v); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// mappingValue returns the index in Content of the value for key, or -1
+func mappingValue(n *kyaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// containerFor returns an empty map or list node suitable for holding the
+// path part p
+func containerFor(p string) (*kyaml.Node, error) {
+	if p == AppendPart {
+		return newSequenceNode(), nil
+	}
+	if i, err := strconv.Atoi(p); err == nil {
+		if i < 0 {
+			return nil, errors.New("index less than 0")
+		}
+		return newSequenceNode(), nil
+	}
+	return newMappingNode(), nil
+}
+
+// replaceNode returns v carrying over the comments, and for strings the
+// quoting style, of the node it replaces.
+func replaceNode(old *kyaml.Node, v *kyaml.Node) *kyaml.Node {
+	if old == nil {
+		return v
+	}
+	v.HeadComment = old.HeadComment
+	v.LineComment = old.LineComment
+	v.FootComment = old.FootComment
+	if old.Kind == kyaml.ScalarNode && v.Kind == kyaml.ScalarNode && old.ShortTag() == "!!str" && v.ShortTag() == "!!str" {
+		if old.Style&(kyaml.DoubleQuotedStyle|kyaml.SingleQuotedStyle) != 0 && !strings.Contains(v.Value, "\n") {
+			v.Style = old.Style
+		}
+	}
+	return v
+}
+
+// setNode sets v at path within n
+func setNode(n *kyaml.Node, path []string, v *kyaml.Node) error {
+	l := len(path)
+	if l == 0 {
+		return errors.New("0 length path")
+	}
+	p := path[0]
+	switch n.Kind {
+	case kyaml.MappingNode:
+		i := mappingValue(n, p)
+		if i == -1 {
+			n.Content = append(n.Content, &kyaml.Node{Kind: kyaml.ScalarNode, Tag: "!!str", Value: p}, newNullNode())
+			i = len(n.Content) - 1
+		}
+		return setChild(n, i, path, v)
+	case kyaml.SequenceNode:
+		i := len(n.Content)
+		if p != AppendPart {
+			var err error
+			if i, err = strconv.Atoi(p); err != nil {
+				return err
+			}
+		}
+		if i < 0 {
+			return errors.New("index less than 0")
+		}
+		if i > len(n.Content) {
+			return fmt.Errorf("index %d out of range for list of length %d", i, len(n.Content))
+		}
+		if i == len(n.Content) {
+			n.Content = append(n.Content, newNullNode())
+		}
+		return setChild(n, i, path, v)
+	default:
+		return fmt.Errorf("cannot set %s in a scalar value", p)
+	}
+}
+
+// setChild sets v at path within n.Content[i], replacing missing
+// containers on the way.
+func setChild(n *kyaml.Node, i int, path []string, v *kyaml.Node) error {
+	child := n.Content[i]
+	if len(path) > 1 {
+		child = expandAlias(n, i)
+	}
+	if len(path) == 1 {
+		n.Content[i] = replaceNode(child, v)
+		return nil
+	}
+	if isNullNode(child) {
+		c, err := containerFor(path[1])
+		if err != nil {
+			return err
+		}
+		child = replaceNode(child, c)
+		n.Content[i] = child
+	}
+	return setNode(child, path[1:], v)
+}
+
+// expandAlias replaces an alias at n.Content[i] with a copy of the anchored
+// node, such that edits below the alias do not change the anchored node,
+// and returns n.Content[i]
+func expandAlias(n *kyaml.Node, i int) *kyaml.Node {
+	if n.Content[i].Kind == kyaml.AliasNode {
+		n.Content[i] = replaceNode(n.Content[i], copyNode(n.Content[i].Alias))
+		n.Content[i].Anchor = ""
+	}
+	return n.Content[i]
+}
+
+// copyNode returns a deep copy of n. Aliases within n are kept.
+func copyNode(n *kyaml.Node) *kyaml.Node {
+	c := *n
+	c.Content = make([]*kyaml.Node, len(n.Content))
+	for i, v := range n.Content {
+		c.Content[i] = copyNode(v)
+	}
+	return &c
+}
+
+// lookupNode returns the node at path within n or nil
+func lookupNode(n *kyaml.Node, path []string) *kyaml.Node {
+	for _, p := range path {
+		if n.Kind == kyaml.AliasNode {
+			n = n.Alias
+		}
+		switch n.Kind {
+		case kyaml.MappingNode:
+			i := mappingValue(n, p)
+			if i == -1 {
+				return nil
+			}
+			n = n.Content[i]
+		case kyaml.SequenceNode:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(n.Content) {
+				return nil
+			}
+			n = n.Content[i]
+		default:
+			return nil
+		}
+	}
+	return n
+}
+
+// checkPrecondition returns ErrPreconditionFailed if the value at path
+// within n does not satisfy the Expect or IfAbsent condition of e
+func checkPrecondition(n *kyaml.Node, path []string, e Edit) error {
+	current := lookupNode(n, path)
+	if e.IfAbsent && !isNullNode(current) {
+		return fmt.Errorf("%w: %s already has a value", ErrPreconditionFailed, e.Path)
+	}
+	if e.Expect == nil {
+		return nil
+	}
+	if isNullNode(current) {
+		return fmt.Errorf("%w: %s has no value, expected %q", ErrPreconditionFailed, e.Path, *e.Expect)
+	}
+	if current.Kind != kyaml.ScalarNode || current.Value != *e.Expect {
+		return fmt.Errorf("%w: %s does not have the expected value %q", ErrPreconditionFailed, e.Path, *e.Expect)
+	}
+	return nil
+}
+
+// unsetNode removes the value at path from n. Maps left empty by the
+// removal are removed.
+func unsetNode(n *kyaml.Node, path []string) error {
+	p := path[0]
+	switch n.Kind {
+	case kyaml.MappingNode:
+		i := mappingValue(n, p)
+		if i == -1 {
+			return errors.New("key not found")
+		}
+		if len(path) > 1 {
+			if err := unsetNode(expandAlias(n, i), path[1:]); err != nil {
+				return err
+			}
+			if !isEmptyMapping(n.Content[i]) {
+				return nil
+			}
+		}
+		n.Content = append(n.Content[:i-1], n.Content[i+1:]...)
+		return nil
+	case kyaml.SequenceNode:
+		i, err := strconv.Atoi(p)
+		if err != nil {
+			return err
+		}
+		if i < 0 || i >= len(n.Content) {
+			return errors.New("index out of range")
+		}
+		if len(path) > 1 {
+			if err := unsetNode(expandAlias(n, i), path[1:]); err != nil {
+				return err
+			}
+			if !isEmptyMapping(n.Content[i]) {
+				return nil
+			}
+		}
+		n.Content = append(n.Content[:i], n.Content[i+1:]...)
+		return nil
+	default:
+		return errors.New("unhandled type")
+	}
+}
+
+func isEmptyMapping(n *kyaml.Node) bool {
+	return n.Kind == kyaml.MappingNode && len(n.Content) == 0
+}
@@ -0,0 +1,193 @@
+package cfg
+
+import (
+	"fmt"
+	"github.com/ghodss/yaml"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sort"
+)
+
+// EnvironmentsKey is the key of simple-ops.yml declaring the allowed
+// environments
+const EnvironmentsKey = "environments"
+
+type (
+	// Environment is the metadata of a declared environment
+	Environment struct {
+		Cluster     string `json:"cluster,omitempty"`
+		KubeVersion string `json:"kubeVersion,omitempty"`
+	}
+	// Environments are the environments declared in simple-ops.yml keyed by
+	// name. A nil Environments allows any environment.
+	Environments map[string]*Environment
+	// EnvironmentSummary is an environment and the components deployed to it
+	EnvironmentSummary struct {
+		Name string `json:"name"`
+		Environment
+		Components []string `json:"components"`
+	}
+)
+
+// Environments returns the environments declared in simple-ops.yml, or nil
+// if none are declared
+func (s Svc) Environments() (Environments, error) {
+	global, _, err := s.getGlobalConfig()
+	if err != nil {
+		return nil, err
+	}
+	return parseEnvironments(global)
+}
+
+// EnvironmentSummaries returns the declared environments, or the
+// environments of configured deploys if none are declared, with the
+// components deployed to each
+func (s Svc) EnvironmentSummaries() ([]EnvironmentSummary, error) {
+	envs, err := s.Environments()
+	if err != nil {
+		return nil, err
+	}
+	deploys, err := s.Deploys()
+	if err != nil {
+		return nil, err
+	}
+	summaries := make(map[string]*EnvironmentSummary)
+	for name, e := range envs {
+		summaries[name] = &EnvironmentSummary{Name: name, Environment: *e, Components: []string{}}
+	}
+	for _, d := range deploys {
+		if _, ok := summaries[d.Environment]; !ok {
+			summaries[d.Environment] = &EnvironmentSummary{Name: d.Environment, Components: []string{}}
+		}
+		summaries[d.Environment].Components = append(summaries[d.Environment].Components, d.Component)
+	}
+	out := make([]EnvironmentSummary, 0, len(summaries))
+	for _, e := range summaries {
+		sort.Strings(e.Components)
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+// parseEnvironments returns the environments declared in the global config
+func parseEnvironments(global map[string]interface{}) (Environments, error) {
+	v, ok := global[EnvironmentsKey]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	envs := Environments{}
+	if err := yaml.Unmarshal(b, &envs); err != nil {
+		return nil, fmt.Errorf("%s: %s", EnvironmentsKey, err)
+	}
+	for k, e := range envs {
+		if e == nil {
+			envs[k] = &Environment{}
+		}
+	}
+	return envs, nil
+}
+
+// Names returns the sorted names of the environments
+func (e Environments) Names() []string {
+	names := make([]string, 0, len(e))
+	for k := range e {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check returns an error if environments are declared and env is not one of
+// them, suggesting the closest declared environment
+func (e Environments) Check(env string) error {
+	if e == nil {
+		return nil
+	}
+	if _, ok := e[env]; ok {
+		return nil
+	}
+	if s := e.closest(env); s != "" {
+		return fmt.Errorf("unknown environment %s, did you mean %s?", env, s)
+	}
+	return fmt.Errorf("unknown environment %s", env)
+}
+
+// closest returns the declared environment within an edit distance of 2 of
+// env, if any
+func (e Environments) closest(env string) string {
+	best, distance := "", 3
+	for _, name := range e.Names() {
+		if d := editDistance(env, name); d < distance {
+			best, distance = name, d
+		}
+	}
+	return best
+}
+
+// lintEnvironments returns a Diagnostic for each deploy and matrix params
+// key of a config file of version which is not a declared environment
+func (e Environments) lintEnvironments(file string, content []byte, version string) Diagnostics {
+	var diags Diagnostics
+	if e == nil {
+		return nil
+	}
+	var doc kyaml.Node
+	if err := kyaml.Unmarshal(content, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	// problems with the apiVersion are reported by validation
+	if _, err := nodeAPIVersion(doc.Content[0], version); err != nil {
+		return nil
+	}
+	for _, path := range [][]string{{"deploy"}, {"matrix", "params"}} {
+		n := lookupNode(doc.Content[0], path)
+		if n == nil || n.Kind != kyaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			if err := e.Check(key.Value); err != nil {
+				diags = append(diags, Diagnostic{
+					File:    file,
+					Line:    key.Line,
+					Column:  key.Column,
+					Message: fmt.Sprintf("%s.%s: %s", JoinPath(path...), key.Value, err),
+				})
+			}
+		}
+	}
+	return diags
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,83 @@
+package cfg
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"gotest.tools/assert"
+	"testing"
+)
+
+const testEnvironments = `environments:
+  prod:
+    cluster: k8s-prod
+    kubeVersion: 1.24.0
+  staging:
+`
+
+func TestSvc_Deploys_environments(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	writeConfigFiles(t, c, map[string]string{
+		"/test/config/app.yml": "deploy:\n  prod: {}\n  staging: {}\n",
+	})
+	if err := afero.WriteFile(c.appFs, "/test/simple-ops.yml", []byte(testEnvironments), DefaultConfigFsPerm); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := c.Deploys()
+	assert.NilError(t, err)
+	assert.Equal(t, len(actual), 2)
+	assert.Equal(t, actual[0].KubeVersion, "1.24.0")
+	assert.Equal(t, actual[1].KubeVersion, "")
+
+	if err := afero.WriteFile(c.appFs, "/test/config/web.yml", []byte("deploy:\n  prdo: {}\n"), DefaultConfigFsPerm); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Deploys()
+	assert.Error(t, err, "deploy prdo.web: unknown environment prdo, did you mean prod?")
+}
+
+func TestSvc_EnvironmentSummaries(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	writeConfigFiles(t, c, map[string]string{
+		"/test/config/app.yml": "deploy:\n  prod: {}\n",
+		"/test/config/web.yml": "deploy:\n  prod: {}\n",
+	})
+	if err := afero.WriteFile(c.appFs, "/test/simple-ops.yml", []byte(testEnvironments), DefaultConfigFsPerm); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := c.EnvironmentSummaries()
+	assert.NilError(t, err)
+	expected := []EnvironmentSummary{
+		{Name: "prod", Environment: Environment{Cluster: "k8s-prod", KubeVersion: "1.24.0"}, Components: []string{"app", "web"}},
+		{Name: "staging", Components: []string{}},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
+
+func TestSvc_Lint_environments(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	files := map[string]string{
+		"/test/simple-ops.yml":       testEnvironments,
+		"/test/environments/dev.yml": "labels:\n  a: b\n",
+		"/test/config/a.yml":         "deploy:\n  prod: {}\n  stagin: {}\nmatrix:\n  params:\n    qa: {}\n",
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(c.appFs, path, []byte(content), DefaultConfigFsPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	actual, err := c.Lint()
+	assert.NilError(t, err)
+	expected := Diagnostics{
+		{File: "config/a.yml", Line: 3, Column: 3, Message: "deploy.stagin: unknown environment stagin, did you mean staging?"},
+		{File: "config/a.yml", Line: 6, Column: 5, Message: "matrix.params.qa: unknown environment qa"},
+		{File: "environments/dev.yml", Message: "unknown environment dev"},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, editDistance("prod", "prod"), 0)
+	assert.Equal(t, editDistance("prdo", "prod"), 2)
+	assert.Equal(t, editDistance("stagin", "staging"), 1)
+	assert.Equal(t, editDistance("", "dev"), 3)
+}
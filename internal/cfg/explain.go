@@ -0,0 +1,355 @@
+package cfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sort"
+)
+
+type (
+	// Source is the location in a config file a value was set
+	Source struct {
+		File   string `json:"file"`
+		Line   int    `json:"line,omitempty"`
+		Column int    `json:"column,omitempty"`
+	}
+	// SourcedValue is a config value and the location it was set
+	SourcedValue struct {
+		Value interface{} `json:"value"`
+		Source
+	}
+	// Explanation is a leaf value of a merged Deploy, where it was set and
+	// the values it overrode, most recent first. Configured is the value as
+	// set when it differs from the value, a template or a reference.
+	Explanation struct {
+		Path string `json:"path"`
+		SourcedValue
+		Configured interface{}    `json:"configured,omitempty"`
+		Overrides  []SourcedValue `json:"overrides,omitempty"`
+	}
+	Explanations []Explanation
+	// explainer replays the merge of config layers recording the source of
+	// each leaf value
+	explainer struct {
+		entries map[string]*Explanation
+		parts   map[string][]string
+		file    string
+	}
+)
+
+// DefaultSource is the Source of values not set in any config file
+const DefaultSource = "default"
+
+// Explain returns every leaf value of the deploy of component to
+// environment with the file and line it was set and any values it
+// overrode. Values are those of Deploys, with templates executed and
+// references resolved, and sources are found by replaying the merge of the
+// config files in the same order.
+func (s Svc) Explain(component string, environment string) (Explanations, error) {
+	d, err := s.GetDeploy(component, environment)
+	if err != nil {
+		return nil, err
+	}
+	merged, _, err := s.mergedConfigs()
+	if err != nil {
+		return nil, err
+	}
+	var final map[string]interface{}
+	for _, md := range merged {
+		if md.component == component {
+			final = md.config[environment]
+		}
+	}
+	order := d.MergeOrder
+	if len(order) == 0 {
+		order = []string{environment}
+	}
+	envPaths, err := s.getEnvironmentPaths()
+	if err != nil {
+		return nil, err
+	}
+	configPaths, err := s.getConfigPaths()
+	if err != nil {
+		return nil, err
+	}
+	files := []string{GlobalConfigFile}
+	if path, ok := envPaths[environment]; ok {
+		files = append(files, path)
+	}
+	defaults := s.getDefaultsPaths(configPaths[component])
+	files = append(append(files, defaults...), configPaths[component])
+	// files with deploy blocks, in merge order
+	deployFiles := append(append([]string{GlobalConfigFile}, defaults...), configPaths[component])
+	_, version, err := s.getGlobalConfig()
+	if err != nil {
+		return nil, err
+	}
+	roots := make(map[string]*kyaml.Node, len(files))
+	for _, f := range files {
+		doc, err := s.readConfigDocument(filepath.Join(s.wd, f))
+		if err != nil {
+			return nil, err
+		}
+		def := version
+		if f == GlobalConfigFile {
+			def = LegacyAPIVersion
+		}
+		if _, err := nodeAPIVersion(doc.root(), def); err != nil {
+			return nil, fmt.Errorf("%s: %s", f, err)
+		}
+		roots[f] = doc.root()
+	}
+
+	e := &explainer{entries: make(map[string]*Explanation), parts: make(map[string][]string)}
+	for _, f := range files {
+		e.file = f
+		n := roots[f]
+		for _, k := range []string{"deploy", "name", "matrix", EnvironmentsKey, APIVersionKey} {
+			n = withoutNodeKey(n, k)
+		}
+		e.merge(n, nil)
+	}
+	// deploy blocks of the global config, directory defaults then the
+	// component config
+	for _, env := range order {
+		for _, f := range deployFiles {
+			e.file = f
+			if f == configPaths[component] {
+				e.mergeMatrix(roots[f], env)
+			}
+			if n := lookupNode(roots[f], []string{"deploy", env}); n != nil && n.Kind == kyaml.MappingNode {
+				e.merge(n, nil)
+			}
+		}
+	}
+	if c, ok := e.entries["chain"]; !ok || isEmptyList(c.Value) {
+		e.file = DefaultSource
+		e.set([]string{"chain"}, d.Chain, nil)
+		final = withoutKey(final, "chain")
+		final["chain"] = d.Chain
+	}
+	out := e.explain(final, nil)
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Path < out[j].Path
+	})
+	return out, nil
+}
+
+// explain returns an explanation of each leaf of the merged config v at p,
+// with the source of the value replayed at p, or at the reference p or a
+// parent of p resolved to
+func (e *explainer) explain(v interface{}, p []string) Explanations {
+	if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+		var out Explanations
+		for k := range m {
+			out = append(out, e.explain(m[k], append(append([]string{}, p...), k))...)
+		}
+		return out
+	}
+	x := Explanation{Path: JoinPath(p...), SourcedValue: SourcedValue{Value: v, Source: Source{File: DefaultSource}}}
+	for i := len(p); i > 0; i-- {
+		if current, ok := e.entries[JoinPath(p[:i]...)]; ok {
+			x.Source, x.Overrides = current.Source, current.Overrides
+			if i == len(p) && jsonEqual(current.Value, v) {
+				// the value as decoded from the config file keeps integers
+				x.Value = current.Value
+			} else {
+				x.Configured = current.Value
+			}
+			break
+		}
+		if current, ok := e.entries[JoinPath(append(append([]string{}, p[:i]...), RefKey)...)]; ok {
+			x.Source, x.Overrides = current.Source, current.Overrides
+			x.Configured = map[string]interface{}{RefKey: current.Value}
+			break
+		}
+	}
+	return Explanations{x}
+}
+
+// jsonEqual reports whether a and b are the same json value
+func jsonEqual(a interface{}, b interface{}) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	var va, vb interface{}
+	if json.Unmarshal(ja, &va) != nil || json.Unmarshal(jb, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// merge merges the mapping node n at prefix following the rules of
+// MergeMaps
+func (e *explainer) merge(n *kyaml.Node, prefix []string) {
+	values := make(map[string]*kyaml.Node, len(n.Content)/2)
+	keys := make([]string, 0, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k := n.Content[i].Value
+		if _, ok := values[k]; !ok {
+			keys = append(keys, k)
+		}
+		values[k] = n.Content[i+1]
+	}
+	sortDirectives(keys)
+	for _, k := range keys {
+		v := values[k]
+		if v.Kind == kyaml.AliasNode {
+			v = v.Alias
+		}
+		key, directive := splitDirective(k)
+		p := append(append([]string{}, prefix...), key)
+		switch {
+		case v.Kind == kyaml.ScalarNode && v.Value == DeleteMarker:
+			e.remove(p)
+		case directive != "":
+			e.mergeDirective(p, v, directive)
+		case v.Kind == kyaml.MappingNode:
+			e.removeAt(p)
+			e.merge(v, p)
+			if !e.has(p) {
+				e.set(p, map[string]interface{}{}, v)
+			}
+		case isNullNode(v) && e.hasChildren(p):
+		default:
+			e.set(p, decodeNode(v), v)
+		}
+	}
+}
+
+// mergeMatrix merges the matrix deploy config and params for env of the
+// component config root, if env is in the matrix
+func (e *explainer) mergeMatrix(root *kyaml.Node, env string) {
+	params := lookupNode(root, []string{"matrix", "params", env})
+	if params == nil {
+		return
+	}
+	if n := lookupNode(root, []string{"matrix", "deploy"}); n != nil && n.Kind == kyaml.MappingNode {
+		e.merge(n, nil)
+	}
+	if params.Kind == kyaml.MappingNode {
+		e.merge(params, []string{"params"})
+	}
+}
+
+// mergeDirective merges v at p following the rules of mergeDirective
+func (e *explainer) mergeDirective(p []string, v *kyaml.Node, directive string) {
+	if v.Kind == kyaml.MappingNode {
+		e.remove(p)
+		e.merge(v, p)
+		if !e.has(p) {
+			e.set(p, map[string]interface{}{}, v)
+		}
+		return
+	}
+	value := decodeNode(v)
+	if current, ok := e.entries[JoinPath(p...)]; ok {
+		value = mergeDirective(current.Value, value, directive)
+	} else {
+		e.remove(p)
+	}
+	e.set(p, value, v)
+}
+
+// set records value at p as set by node n, overriding any current value
+func (e *explainer) set(p []string, value interface{}, n *kyaml.Node) {
+	key := JoinPath(p...)
+	x := &Explanation{Path: key, SourcedValue: SourcedValue{Value: value, Source: e.source(n)}}
+	if current, ok := e.entries[key]; ok {
+		x.Overrides = append([]SourcedValue{current.SourcedValue}, current.Overrides...)
+	}
+	e.remove(p)
+	e.removeAt(p)
+	e.entries[key] = x
+	e.parts[key] = p
+}
+
+func (e *explainer) source(n *kyaml.Node) Source {
+	if n == nil {
+		return Source{File: e.file}
+	}
+	return Source{File: e.file, Line: n.Line, Column: n.Column}
+}
+
+// has reports whether there is a value at or below p
+func (e *explainer) has(p []string) bool {
+	for k := range e.entries {
+		if hasPrefix(e.parts[k], p) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasChildren reports whether there is a value below p
+func (e *explainer) hasChildren(p []string) bool {
+	for k := range e.entries {
+		if parts := e.parts[k]; len(parts) > len(p) && hasPrefix(parts, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes the values at and below p
+func (e *explainer) remove(p []string) {
+	for k := range e.entries {
+		if hasPrefix(e.parts[k], p) {
+			delete(e.entries, k)
+		}
+	}
+}
+
+// removeAt removes values at p and above p, which are replaced when a value
+// is set below them
+func (e *explainer) removeAt(p []string) {
+	for i := 1; i <= len(p); i++ {
+		delete(e.entries, JoinPath(p[:i]...))
+	}
+}
+
+func hasPrefix(parts []string, prefix []string) bool {
+	if len(parts) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if parts[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isEmptyList(v interface{}) bool {
+	l, ok := v.([]interface{})
+	return ok && len(l) == 0
+}
+
+// decodeNode returns the value of n
+func decodeNode(n *kyaml.Node) interface{} {
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return n.Value
+	}
+	return v
+}
+
+// withoutNodeKey returns a shallow copy of the mapping node n without key k
+func withoutNodeKey(n *kyaml.Node, k string) *kyaml.Node {
+	out := *n
+	out.Content = nil
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value != k {
+			out.Content = append(out.Content, n.Content[i], n.Content[i+1])
+		}
+	}
+	return &out
+}
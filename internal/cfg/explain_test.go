@@ -0,0 +1,117 @@
+package cfg
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestSvc_Explain(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	files := map[string]string{
+		"/test/simple-ops.yml":        "labels:\n  team: a\nvalues:\n  replicas: 1\n",
+		"/test/environments/prod.yml": "values:\n  region: eu\n",
+		"/test/config/a.yml": `chart: a.tgz
+chain:
+- helm
+values:
+  replicas: 2
+  hosts: [a]
+deploy:
+  prod:
+    values:
+      replicas: 3
+      hosts$append: [b]
+    labels:
+      team: $delete
+`,
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(c.appFs, path, []byte(content), DefaultConfigFsPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	actual, err := c.Explain("a", "prod")
+	assert.NilError(t, err)
+	expected := Explanations{
+		{Path: "chain", SourcedValue: SourcedValue{Value: []interface{}{"helm"}, Source: Source{File: "config/a.yml", Line: 3, Column: 1}}},
+		{Path: "chart", SourcedValue: SourcedValue{Value: "a.tgz", Source: Source{File: "config/a.yml", Line: 1, Column: 8}}},
+		{Path: "labels", SourcedValue: SourcedValue{Value: map[string]interface{}{}, Source: Source{File: "config/a.yml", Line: 13, Column: 7}}},
+		{
+			Path:         "values.hosts",
+			SourcedValue: SourcedValue{Value: []interface{}{"a", "b"}, Source: Source{File: "config/a.yml", Line: 11, Column: 21}},
+			Overrides: []SourcedValue{
+				{Value: []interface{}{"a"}, Source: Source{File: "config/a.yml", Line: 6, Column: 10}},
+			},
+		},
+		{Path: "values.region", SourcedValue: SourcedValue{Value: "eu", Source: Source{File: "environments/prod.yml", Line: 2, Column: 11}}},
+		{
+			Path:         "values.replicas",
+			SourcedValue: SourcedValue{Value: 3, Source: Source{File: "config/a.yml", Line: 10, Column: 17}},
+			Overrides: []SourcedValue{
+				{Value: 2, Source: Source{File: "config/a.yml", Line: 5, Column: 13}},
+				{Value: 1, Source: Source{File: "simple-ops.yml", Line: 4, Column: 13}},
+			},
+		},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
+
+func TestSvc_Explain_matrix(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	files := map[string]string{
+		"/test/simple-ops.yml": "",
+		"/test/config/a.yml": `matrix:
+  params:
+    prod-eu:
+      region: eu
+  deploy:
+    chart: a.tgz
+`,
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(c.appFs, path, []byte(content), DefaultConfigFsPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	actual, err := c.Explain("a", "prod-eu")
+	assert.NilError(t, err)
+	assert.Equal(t, len(actual), 3)
+	assert.DeepEqual(t, actual[1], Explanation{Path: "chart", SourcedValue: SourcedValue{Value: "a.tgz", Source: Source{File: "config/a.yml", Line: 6, Column: 12}}})
+	assert.DeepEqual(t, actual[2], Explanation{Path: "params.region", SourcedValue: SourcedValue{Value: "eu", Source: Source{File: "config/a.yml", Line: 4, Column: 15}}})
+}
+
+func TestSvc_Explain_templatesAndRefs(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	writeConfigFiles(t, c, map[string]string{
+		"/test/config/db.yml": "deploy:\n  prod:\n    values:\n      port: 5432\n",
+		"/test/config/a.yml": `namespace:
+  name: ${{ .Environment }}-a
+values:
+  db:
+    $ref: prod.db#values
+deploy:
+  prod: {}
+`,
+	})
+	actual, err := c.Explain("a", "prod")
+	assert.NilError(t, err)
+	d, err := c.GetDeploy("a", "prod")
+	assert.NilError(t, err)
+	assert.Equal(t, d.Namespace.Name, "prod-a")
+	expected := Explanations{
+		{Path: "chain", SourcedValue: SourcedValue{Value: d.Chain, Source: Source{File: DefaultSource}}},
+		{
+			Path:         "namespace.name",
+			SourcedValue: SourcedValue{Value: "prod-a", Source: Source{File: "config/a.yml", Line: 2, Column: 9}},
+			Configured:   "${{ .Environment }}-a",
+		},
+		{
+			Path:         "values.db.port",
+			SourcedValue: SourcedValue{Value: float64(5432), Source: Source{File: "config/a.yml", Line: 5, Column: 11}},
+			Configured:   map[string]interface{}{RefKey: "prod.db#values"},
+		},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
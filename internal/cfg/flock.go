@@ -0,0 +1,34 @@
+package cfg
+
+import "path/filepath"
+
+// lockConfig takes an exclusive advisory lock on the config directory such
+// that concurrent read-modify-write edits of config files are serialised.
+// The directory is created if it does not exist, such that edits creating
+// the first config files are also serialised. The lock is held until unlock
+// is called. File systems without file descriptors, such as an in memory
+// file system, are not locked.
+func (s Svc) lockConfig() (unlock func(), err error) {
+	dir := filepath.Join(s.wd, ConfPath)
+	if err := s.appFs.MkdirAll(dir, DefaultConfigDirPerm); err != nil {
+		return nil, err
+	}
+	f, err := s.appFs.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	fd, ok := f.(interface{ Fd() uintptr })
+	if !ok {
+		_ = f.Close()
+		return func() {}, nil
+	}
+	if err := lockFd(fd.Fd()); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	s.log.Debugf("locked %s", f.Name())
+	return func() {
+		_ = unlockFd(fd.Fd())
+		_ = f.Close()
+	}, nil
+}
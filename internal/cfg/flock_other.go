@@ -0,0 +1,13 @@
+//go:build !(linux || darwin)
+
+package cfg
+
+// advisory locking is not supported on this platform
+
+func lockFd(uintptr) error {
+	return nil
+}
+
+func unlockFd(uintptr) error {
+	return nil
+}
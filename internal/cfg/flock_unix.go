@@ -0,0 +1,13 @@
+//go:build linux || darwin
+
+package cfg
+
+import "syscall"
+
+func lockFd(fd uintptr) error {
+	return syscall.Flock(int(fd), syscall.LOCK_EX)
+}
+
+func unlockFd(fd uintptr) error {
+	return syscall.Flock(int(fd), syscall.LOCK_UN)
+}
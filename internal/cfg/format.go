@@ -0,0 +1,206 @@
+package cfg
+
+import (
+	"fmt"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"strings"
+)
+
+// detectIndent returns the mapping indentation of the yaml document b, the
+// default indentation if b has no nested mapping
+func detectIndent(b []byte) int {
+	lines := strings.Split(string(b), "\n")
+	for i := 0; i+1 < len(lines); i++ {
+		l := strings.TrimRight(lines[i], " ")
+		if l == "" || strings.HasPrefix(strings.TrimSpace(l), "#") || !strings.HasSuffix(l, ":") {
+			continue
+		}
+		next := lines[i+1]
+		content := strings.TrimLeft(next, " ")
+		if content == "" || strings.HasPrefix(content, "#") || strings.HasPrefix(content, "-") {
+			continue
+		}
+		if d := indentOf(next) - indentOf(l); d > 0 {
+			return d
+		}
+	}
+	return kyaml.DefaultIndent
+}
+
+func indentOf(l string) int {
+	return len(l) - len(strings.TrimLeft(l, " "))
+}
+
+// restoreFormatting returns encoded, the re-encoding of an edited document,
+// with the lines unchanged from orig taken from orig, such that blank lines,
+// spacing and the indentation of orig are kept and only edited lines differ.
+// Lines are compared by the yaml nodes starting on them, such that lines the
+// encoder writes differently, for example merge keys, are unchanged when
+// their nodes are. Added lines are indented as the closest unchanged line at
+// the same encoded indentation.
+func restoreFormatting(orig []byte, encoded []byte) []byte {
+	a := splitLines(orig)
+	b := splitLines(encoded)
+	matches := matchLines(lineKeys(orig, a), lineKeys(encoded, b))
+
+	var out []string
+	// indentation of orig for each encoded indentation seen so far
+	indents := map[int]int{}
+	reindent := func(l string) string {
+		enc := indentOf(l)
+		i, ok := indents[enc]
+		if !ok {
+			// relative to the closest shallower known indentation
+			closest := -1
+			for k := range indents {
+				if k < enc && k > closest {
+					closest = k
+				}
+			}
+			i = enc
+			if closest != -1 {
+				i = indents[closest] + enc - closest
+			}
+		}
+		return strings.Repeat(" ", i) + l[enc:]
+	}
+	i, j := 0, 0
+	for _, m := range append(matches, [2]int{len(a), len(b)}) {
+		// the lines of orig up to the match are removed or changed, and the
+		// encoded lines up to the match are added or changed
+		removed := a[i:m[0]]
+		added := b[j:m[1]]
+		allBlank := true
+		first, last := len(removed), -1
+		for k, l := range removed {
+			if strings.TrimSpace(l) != "" {
+				allBlank = false
+				if k < first {
+					first = k
+				}
+				last = k
+			}
+		}
+		eof := m[0] == len(a)
+		switch {
+		case allBlank && isBlank(added):
+			out = append(out, removed...)
+		case allBlank:
+			// blank lines between unchanged lines are kept, after lines
+			// added at the end of the preceding block
+			for _, l := range added {
+				out = append(out, reindent(l))
+			}
+			if !eof || len(added) == 0 {
+				out = append(out, removed...)
+			}
+		default:
+			lead, trail := removed[:first], removed[last+1:]
+			if len(added) == 0 && (eof || len(lead) > 0) {
+				// a removed block leaves a single separating blank line
+				trail = nil
+				if eof {
+					lead = nil
+				}
+			}
+			out = append(out, lead...)
+			for _, l := range added {
+				out = append(out, reindent(l))
+			}
+			out = append(out, trail...)
+		}
+		if m[0] < len(a) {
+			indents[indentOf(b[m[1]])] = indentOf(a[m[0]])
+			out = append(out, a[m[0]])
+		}
+		i, j = m[0]+1, m[1]+1
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(out, "\n") + "\n")
+}
+
+func isBlank(lines []string) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// splitLines returns the lines of b without the final newline
+func splitLines(b []byte) []string {
+	s := strings.TrimSuffix(string(b), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// lineKeys returns a key for each of lines of the yaml document b, which
+// is equal for lines with equal nodes. The key of a line no node starts on,
+// such as a comment, is its text without indentation, and of a blank line
+// is empty.
+func lineKeys(b []byte, lines []string) []string {
+	keys := make([]string, len(lines))
+	var doc kyaml.Node
+	if err := kyaml.Unmarshal(b, &doc); err == nil {
+		addLineKeys(&doc, keys)
+	}
+	for i, l := range lines {
+		if keys[i] == "" && strings.TrimSpace(l) != "" {
+			keys[i] = "text " + strings.TrimSpace(l)
+		}
+	}
+	return keys
+}
+
+// addLineKeys appends to keys the kind, tag, style, anchor, value and line
+// comment of n and the nodes within it at the line each starts on
+func addLineKeys(n *kyaml.Node, keys []string) {
+	if i := n.Line - 1; i >= 0 && i < len(keys) {
+		keys[i] += fmt.Sprintf("%d %s %d &%q %q %q;", n.Kind, n.ShortTag(), n.Style&^kyaml.TaggedStyle, n.Anchor, n.Value, n.LineComment)
+	}
+	for _, c := range n.Content {
+		addLineKeys(c, keys)
+	}
+}
+
+// matchLines returns the pairs of indexes of a longest common subsequence
+// of a and b, comparing the non-empty line keys of lineKeys
+func matchLines(a []string, b []string) [][2]int {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] != "" && a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var matches [][2]int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] != "" && a[i] == b[j]:
+			matches = append(matches, [2]int{i, j})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return matches
+}
@@ -0,0 +1,48 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// matrixDeploys expands the matrix of component config m into deploy blocks
+// keyed by environment. Each environment named in matrix.params gets the
+// matrix.deploy config and its params, e.g.
+//
+//	matrix:
+//	  params:
+//	    prod-eu: {region: eu-west-1}
+//	    prod-us: {region: us-east-1}
+//	  deploy:
+//	    values:
+//	      region: ${{ .Params.region }}
+func matrixDeploys(m map[string]interface{}) (map[string]interface{}, error) {
+	if m["matrix"] == nil {
+		return nil, nil
+	}
+	matrix, ok := m["matrix"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("matrix must be a map")
+	}
+	params, ok := matrix["params"].(map[string]interface{})
+	if !ok || len(params) == 0 {
+		return nil, errors.New("matrix params must be a map of environment to params")
+	}
+	deploy := map[string]interface{}{}
+	if matrix["deploy"] != nil {
+		if deploy, ok = matrix["deploy"].(map[string]interface{}); !ok {
+			return nil, errors.New("matrix deploy must be a map")
+		}
+	}
+	ds := make(map[string]interface{}, len(params))
+	for env, p := range params {
+		if p == nil {
+			p = map[string]interface{}{}
+		}
+		if _, ok := p.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("matrix params %s must be a map", env)
+		}
+		ds[env] = MergeMaps(deploy, map[string]interface{}{"params": p})
+	}
+	return ds, nil
+}
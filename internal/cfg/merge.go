@@ -0,0 +1,149 @@
+package cfg
+
+import (
+	"sort"
+	"strings"
+)
+
+// Merge directives are appended to a config key, e.g.
+// kustomizationPaths$append, to change how a list value is merged with the
+// value of the key it overrides.
+const (
+	// DirectiveAppend appends the list to the list it overrides
+	DirectiveAppend = "append"
+	// DirectivePrepend prepends the list to the list it overrides
+	DirectivePrepend = "prepend"
+	// DirectiveReplace replaces the value it overrides without merging
+	DirectiveReplace = "replace"
+	// DirectiveMerge merges list items with the item of the list it
+	// overrides having the same name, appending items not found
+	DirectiveMerge = "merge"
+	// DirectiveSeparator separates a key from its merge directive
+	DirectiveSeparator = "$"
+	// MergeKey identifies list items merged by DirectiveMerge
+	MergeKey = "name"
+	// DeleteMarker is the value of a key to be removed from the merged
+	// config, e.g. an inherited helm value
+	DeleteMarker = "$delete"
+)
+
+// MergeDirectives are the merge directives understood by MergeMaps
+var MergeDirectives = []string{DirectiveAppend, DirectivePrepend, DirectiveReplace, DirectiveMerge}
+
+// splitDirective returns the key and merge directive of k. Keys without a
+// known directive suffix are returned unchanged.
+func splitDirective(k string) (string, string) {
+	i := strings.LastIndex(k, DirectiveSeparator)
+	if i < 1 {
+		return k, ""
+	}
+	for _, d := range MergeDirectives {
+		if k[i+1:] == d {
+			return k[:i], d
+		}
+	}
+	return k, ""
+}
+
+// directiveOrder returns the keys of m sorted such that keys without a
+// directive are merged before keys with one, e.g. a list is set before
+// being appended to.
+func directiveOrder(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sortDirectives(keys)
+	return keys
+}
+
+// sortDirectives sorts keys such that keys without a directive come first
+func sortDirectives(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		_, di := splitDirective(keys[i])
+		_, dj := splitDirective(keys[j])
+		if (di == "") != (dj == "") {
+			return di == ""
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+// mergeDirective merges v into the value a it overrides as directed. A
+// list directive applied to values which are not lists replaces a.
+func mergeDirective(a, v interface{}, directive string) interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		v = mergeMaps(nil, m)
+	}
+	al, aok := a.([]interface{})
+	vl, vok := v.([]interface{})
+	if !vok {
+		return v
+	}
+	if a != nil && !aok {
+		return resolveList(vl)
+	}
+	switch directive {
+	case DirectiveAppend:
+		return append(append([]interface{}{}, al...), resolveList(vl)...)
+	case DirectivePrepend:
+		return append(resolveList(vl), al...)
+	case DirectiveMerge:
+		return mergeByName(al, vl)
+	default:
+		return resolveList(vl)
+	}
+}
+
+// resolveList returns a copy of l with the merge directives and delete
+// markers of its map items resolved, which have no inherited value to merge
+// with or delete from
+func resolveList(l []interface{}) []interface{} {
+	out := make([]interface{}, len(l))
+	for i, v := range l {
+		out[i] = resolveItem(v)
+	}
+	return out
+}
+
+func resolveItem(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return mergeMaps(nil, t)
+	case []interface{}:
+		return resolveList(t)
+	default:
+		return v
+	}
+}
+
+// mergeByName merges the items of b into a copy of a. Map items of b are
+// merged into the map item of a with the same name, other items are
+// appended.
+func mergeByName(a, b []interface{}) []interface{} {
+	out := append([]interface{}{}, a...)
+	for _, v := range b {
+		vm, ok := v.(map[string]interface{})
+		i := -1
+		if name, isString := vm[MergeKey].(string); ok && isString {
+			i = indexByName(out, name)
+		}
+		if i == -1 {
+			out = append(out, resolveItem(v))
+			continue
+		}
+		out[i] = MergeMaps(out[i].(map[string]interface{}), vm)
+	}
+	return out
+}
+
+// indexByName returns the index of the map item in l with the given name,
+// or -1
+func indexByName(l []interface{}, name string) int {
+	for i, v := range l {
+		if m, ok := v.(map[string]interface{}); ok && m[MergeKey] == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,79 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AppendPart is the path part which, for a list, refers to a new item
+// appended to the end of the list, e.g. values.ingress.hosts.+
+const AppendPart = "+"
+
+// SplitPath splits a config path such as myapp.deploy.prod.values.image on
+// dots. A part containing dots can be double quoted, e.g.
+// labels."app.kubernetes.io/part-of", or have its dots escaped with a
+// backslash, e.g. podAnnotations.prometheus\.io/scrape. Within quotes a
+// backslash escapes a double quote or backslash.
+func SplitPath(path string) ([]string, error) {
+	var parts []string
+	var part strings.Builder
+	quoted := false // the current part was quoted
+	inQuotes := false
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '\\':
+			if i+1 == len(path) {
+				return nil, fmt.Errorf("invalid path %s: trailing escape", path)
+			}
+			i++
+			part.WriteByte(path[i])
+		case inQuotes && c == '"':
+			inQuotes = false
+		case inQuotes:
+			part.WriteByte(c)
+		case c == '"':
+			if part.Len() > 0 || quoted {
+				return nil, fmt.Errorf("invalid path %s: unexpected quote", path)
+			}
+			inQuotes = true
+			quoted = true
+		case c == '.':
+			if part.Len() == 0 && !quoted {
+				return nil, fmt.Errorf("invalid path %s: empty part", path)
+			}
+			parts = append(parts, part.String())
+			part.Reset()
+			quoted = false
+		default:
+			if quoted {
+				return nil, fmt.Errorf("invalid path %s: unexpected character after quote", path)
+			}
+			part.WriteByte(c)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("invalid path %s: unterminated quote", path)
+	}
+	if part.Len() == 0 && !quoted {
+		if path == "" {
+			return nil, errors.New("empty path")
+		}
+		return nil, fmt.Errorf("invalid path %s: empty part", path)
+	}
+	return append(parts, part.String()), nil
+}
+
+// JoinPath joins parts into a config path, quoting any part that
+// contains dots, quotes or backslashes such that SplitPath returns parts.
+func JoinPath(parts ...string) string {
+	quoted := make([]string, len(parts))
+	for i, p := range parts {
+		if p == "" || strings.ContainsAny(p, `."\`) {
+			p = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(p) + `"`
+		}
+		quoted[i] = p
+	}
+	return strings.Join(quoted, ".")
+}
@@ -0,0 +1,49 @@
+package cfg
+
+import (
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestSplitPath(t *testing.T) {
+	for _, tc := range []struct {
+		path   string
+		expect []string
+		err    string
+	}{
+		{path: "a", expect: []string{"a"}},
+		{path: "a.b.0.c", expect: []string{"a", "b", "0", "c"}},
+		{path: `labels."app.kubernetes.io/part-of"`, expect: []string{"labels", "app.kubernetes.io/part-of"}},
+		{path: `values.podAnnotations.prometheus\.io/scrape`, expect: []string{"values", "podAnnotations", "prometheus.io/scrape"}},
+		{path: `"a\"b\\c".""`, expect: []string{`a"b\c`, ""}},
+		{path: `"prod.eu".app`, expect: []string{"prod.eu", "app"}},
+		{path: "", err: "empty path"},
+		{path: "a..b", err: "invalid path a..b: empty part"},
+		{path: "a.", err: "invalid path a.: empty part"},
+		{path: `a."b`, err: `invalid path a."b: unterminated quote`},
+		{path: `a."b"c`, err: `invalid path a."b"c: unexpected character after quote`},
+		{path: `a.b"c"`, err: `invalid path a.b"c": unexpected quote`},
+		{path: `a\`, err: `invalid path a\: trailing escape`},
+	} {
+		actual, err := SplitPath(tc.path)
+		if tc.err != "" {
+			assert.Error(t, err, tc.err)
+			continue
+		}
+		assert.NilError(t, err)
+		assert.DeepEqual(t, tc.expect, actual)
+	}
+}
+
+func TestJoinPath(t *testing.T) {
+	for _, parts := range [][]string{
+		{"a", "b"},
+		{"labels", "app.kubernetes.io/part-of"},
+		{`a"b\c`, ""},
+	} {
+		actual, err := SplitPath(JoinPath(parts...))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, parts, actual)
+	}
+	assert.Equal(t, JoinPath("labels", "app.kubernetes.io/name"), `labels."app.kubernetes.io/name"`)
+}
@@ -0,0 +1,190 @@
+package cfg
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RefKey is the key of a map referring to a value of another deploy, e.g.
+//
+//	port:
+//	  $ref: prod.postgres#values.service.port
+const RefKey = "$ref"
+
+// refResolver replaces references in deploy config with the value they
+// refer to
+type refResolver struct {
+	deploys map[string]map[string]interface{}
+	stack   []string
+}
+
+// resolveRefs replaces the references in the merged config of deploys with
+// the merged value of the deploy and path they refer to, before the config
+// is read as Deploys such that any value may be a reference. References to
+// references are followed and cycles are errors.
+func resolveRefs(deploys []*mergedDeploys) error {
+	r := &refResolver{deploys: make(map[string]map[string]interface{})}
+	var ids []string
+	for _, md := range deploys {
+		for env, c := range md.config {
+			id := JoinPath(env, md.component)
+			r.deploys[id] = c
+			ids = append(ids, id)
+		}
+	}
+	// sorted for deterministic errors
+	sort.Strings(ids)
+	resolved := make(map[string]map[string]interface{}, len(ids))
+	for _, id := range ids {
+		c := r.deploys[id]
+		if !containsRef(c) {
+			continue
+		}
+		v, err := r.value(c)
+		if err != nil {
+			return fmt.Errorf("deploy %s: %s", id, err)
+		}
+		resolved[id] = v.(map[string]interface{})
+	}
+	for _, md := range deploys {
+		for env := range md.config {
+			if c, ok := resolved[JoinPath(env, md.component)]; ok {
+				md.config[env] = c
+			}
+		}
+	}
+	return nil
+}
+
+// value returns v with references replaced
+func (r *refResolver) value(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if target, ok, err := refTarget(t); ok || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			return r.lookup(target)
+		}
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		// sorted for deterministic errors
+		sort.Strings(keys)
+		out := make(map[string]interface{}, len(t))
+		for _, k := range keys {
+			e, err := r.value(t[k])
+			if err != nil {
+				return nil, err
+			}
+			out[k] = e
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, vv := range t {
+			e, err := r.value(vv)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = e
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// lookup returns the resolved value of a reference target,
+// <environment>.<component>#<path>
+func (r *refResolver) lookup(target string) (interface{}, error) {
+	for i, t := range r.stack {
+		if t == target {
+			return nil, fmt.Errorf("reference cycle: %s -> %s", strings.Join(r.stack[i:], " -> "), target)
+		}
+	}
+	r.stack = append(r.stack, target)
+	defer func() { r.stack = r.stack[:len(r.stack)-1] }()
+
+	i := strings.LastIndex(target, "#")
+	if i == -1 {
+		return nil, fmt.Errorf("invalid reference %s, expected <environment>.<component>#<path>", target)
+	}
+	env, comp, err := DeployIdParts(target[:i])
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference %s: %s", target, err)
+	}
+	m, ok := r.deploys[JoinPath(env, comp)]
+	if !ok {
+		return nil, fmt.Errorf("reference %s: deploy %s not found", target, JoinPath(env, comp))
+	}
+	path, err := SplitPath(target[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("reference %s: %s", target, err)
+	}
+	v, ok := lookupValue(m, path)
+	if !ok {
+		return nil, fmt.Errorf("reference %s: path not found", target)
+	}
+	return r.value(v)
+}
+
+// refTarget returns the target of m if m is a reference
+func refTarget(m map[string]interface{}) (string, bool, error) {
+	v, ok := m[RefKey]
+	if !ok {
+		return "", false, nil
+	}
+	target, isString := v.(string)
+	if !isString || len(m) != 1 {
+		return "", true, fmt.Errorf("%s must be the only key of a map and a string", RefKey)
+	}
+	return target, true, nil
+}
+
+// containsRef returns true if v contains a reference
+func containsRef(v interface{}) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if _, ok := t[RefKey]; ok {
+			return true
+		}
+		for _, vv := range t {
+			if containsRef(vv) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, vv := range t {
+			if containsRef(vv) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupValue returns the value at path within v
+func lookupValue(v interface{}, path []string) (interface{}, bool) {
+	for _, p := range path {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = t[p]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			v = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,140 @@
+package cfg
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"gotest.tools/assert"
+	"testing"
+)
+
+// writeConfigFiles writes files and an empty global config
+func writeConfigFiles(t *testing.T, c *Svc, files map[string]string) {
+	files[c.wd+"/"+GlobalConfigFile] = ""
+	for path, content := range files {
+		if err := afero.WriteFile(c.appFs, path, []byte(content), DefaultConfigFsPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSvc_Deploys_refs(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	writeConfigFiles(t, c, map[string]string{
+		"/test/config/postgres.yml": `deploy:
+  prod:
+    namespace:
+      name: db
+    values:
+      service:
+        port: 5432
+`,
+		"/test/config/api.yml": `values:
+  db:
+    host:
+      $ref: ${{ .Environment }}.postgres#namespace.name
+    port:
+      $ref: prod.postgres#values.service.port
+    proxyPort:
+      $ref: prod.proxy#values.port
+deploy:
+  prod: {}
+`,
+		"/test/config/proxy.yml": `deploy:
+  prod:
+    values:
+      port:
+        $ref: prod.postgres#values.service.port
+`,
+	})
+	actual, err := c.Deploys()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, actual[0].Values, map[string]interface{}{
+		"db": map[string]interface{}{
+			"host":      "db",
+			"port":      float64(5432),
+			"proxyPort": float64(5432),
+		},
+	})
+	assert.Equal(t, actual[0].Environment, "prod")
+	assert.Equal(t, actual[0].Component, "api")
+	assert.DeepEqual(t, actual[2].Values, map[string]interface{}{"port": float64(5432)})
+}
+
+func TestSvc_Deploys_typedRefs(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	writeConfigFiles(t, c, map[string]string{
+		"/test/config/postgres.yml": `chart: postgres.tgz
+namespace:
+  name: db
+labels:
+  tier: data
+deploy:
+  prod: {}
+`,
+		"/test/config/api.yml": `chart:
+  $ref: prod.postgres#chart
+namespace:
+  name:
+    $ref: prod.postgres#namespace.name
+labels:
+  tier:
+    $ref: prod.postgres#labels.tier
+jsonnet:
+  app:
+    inline: std.extVar('tier')
+    values:
+      tier:
+        $ref: prod.postgres#labels.tier
+deploy:
+  prod: {}
+`,
+	})
+	actual, err := c.Deploys()
+	assert.NilError(t, err)
+	assert.Equal(t, actual[0].Component, "api")
+	assert.Equal(t, actual[0].Chart, "postgres.tgz")
+	assert.Equal(t, actual[0].Namespace.Name, "db")
+	assert.DeepEqual(t, actual[0].Labels, map[string]string{"tier": "data"})
+	assert.DeepEqual(t, actual[0].Jsonnet["app"].Values, map[string]string{"tier": "data"})
+}
+
+func TestSvc_Deploys_refErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name:   "missing deploy",
+			config: "deploy:\n  prod:\n    values:\n      a:\n        $ref: prod.db#values.a\n",
+			err:    "deploy prod.app: reference prod.db#values.a: deploy prod.db not found",
+		},
+		{
+			name:   "missing path",
+			config: "deploy:\n  prod:\n    values:\n      a:\n        $ref: prod.app#values.b\n",
+			err:    "deploy prod.app: reference prod.app#values.b: path not found",
+		},
+		{
+			name:   "invalid",
+			config: "deploy:\n  prod:\n    values:\n      a:\n        $ref: prod.app\n",
+			err:    "deploy prod.app: invalid reference prod.app, expected <environment>.<component>#<path>",
+		},
+		{
+			name:   "not only key",
+			config: "deploy:\n  prod:\n    values:\n      a:\n        $ref: prod.app#values.b\n        b: c\n",
+			err:    "deploy prod.app: $ref must be the only key of a map and a string",
+		},
+		{
+			name:   "cycle",
+			config: "deploy:\n  prod:\n    values:\n      a:\n        $ref: prod.app#values.b\n      b:\n        $ref: prod.app#values.a\n",
+			err:    "deploy prod.app: reference cycle: prod.app#values.b -> prod.app#values.a -> prod.app#values.b",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+			writeConfigFiles(t, c, map[string]string{"/test/config/app.yml": tc.config})
+			_, err := c.Deploys()
+			assert.Error(t, err, tc.err)
+		})
+	}
+}
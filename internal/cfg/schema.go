@@ -0,0 +1,253 @@
+package cfg
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"strconv"
+	"strings"
+)
+
+// Schema is the JSON Schema describing simple-ops configuration files
+//
+//go:embed schema.json
+var Schema []byte
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+type (
+	// schema is the subset of JSON Schema used by schema.json
+	schema struct {
+		Ref                  string                `json:"$ref"`
+		Type                 schemaTypes           `json:"type"`
+		Properties           map[string]*schema    `json:"properties"`
+		AdditionalProperties *additionalProperties `json:"additionalProperties"`
+		Items                *schema               `json:"items"`
+		Enum                 []string              `json:"enum"`
+		Definitions          map[string]*schema    `json:"definitions"`
+	}
+	// schemaTypes is either a single type or a list of types
+	schemaTypes []string
+	// additionalProperties is either a boolean or a schema
+	additionalProperties struct {
+		allowed bool
+		schema  *schema
+	}
+	// Diagnostic is a problem found in a config file
+	Diagnostic struct {
+		File    string `json:"file"`
+		Line    int    `json:"line"`
+		Column  int    `json:"column"`
+		Message string `json:"message"`
+	}
+	Diagnostics []Diagnostic
+	validator   struct {
+		root  *schema
+		file  string
+		diags Diagnostics
+		err   error
+	}
+)
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(b, &multi); err != nil {
+		return err
+	}
+	*t = multi
+	return nil
+}
+
+func (t schemaTypes) allows(typ string) bool {
+	if len(t) == 0 {
+		return true
+	}
+	for _, v := range t {
+		if v == typ || (v == "number" && typ == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *additionalProperties) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &a.allowed); err == nil {
+		return nil
+	}
+	a.allowed = true
+	return json.Unmarshal(b, &a.schema)
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+func loadSchema() (*schema, error) {
+	s := &schema{}
+	if err := json.Unmarshal(Schema, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// validateFile validates the yaml content of file, of the apiVersion it
+// declares or version, against the named schema definition returning any
+// problems found, or an error if the schema is invalid
+func validateFile(root *schema, definition string, file string, content []byte, version string) (Diagnostics, error) {
+	v := &validator{root: root, file: file}
+	var doc kyaml.Node
+	if err := kyaml.Unmarshal(content, &doc); err != nil {
+		d := Diagnostic{File: file, Message: err.Error()}
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+			d.Message = m[2]
+		}
+		return Diagnostics{d}, nil
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	n := doc.Content[0]
+	if n.Kind == kyaml.MappingNode {
+		if _, err := nodeAPIVersion(n, version); err != nil {
+			if a := lookupNode(n, []string{APIVersionKey}); a != nil {
+				n = a
+			}
+			v.report(n, "%s", err)
+			return v.diags, nil
+		}
+		n = withoutNodeKey(n, APIVersionKey)
+	}
+	v.validate(&schema{Ref: "#/definitions/" + definition}, n, "")
+	return v.diags, v.err
+}
+
+// resolve returns the schema s refers to, or nil setting v.err if the
+// definition is not found
+func (v *validator) resolve(s *schema) *schema {
+	for s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/definitions/")
+		def, ok := v.root.Definitions[name]
+		if !ok {
+			v.err = fmt.Errorf("schema definition %s not found", s.Ref)
+			return nil
+		}
+		s = def
+	}
+	return s
+}
+
+func (v *validator) report(n *kyaml.Node, format string, args ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{
+		File:    v.file,
+		Line:    n.Line,
+		Column:  n.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validate(s *schema, n *kyaml.Node, path string) {
+	if s = v.resolve(s); s == nil {
+		return
+	}
+	if n.Kind == kyaml.AliasNode {
+		n = n.Alias
+	}
+	if isRefNode(n) {
+		return
+	}
+	typ := nodeType(n)
+	if !s.Type.allows(typ) {
+		v.report(n, "%s: expected %s, got %s", displayPath(path), strings.Join(s.Type, " or "), typ)
+		return
+	}
+	switch n.Kind {
+	case kyaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			p := key.Value
+			if path != "" {
+				p = path + "." + key.Value
+			}
+			if value.Kind == kyaml.ScalarNode && value.Value == DeleteMarker {
+				continue
+			}
+			name, directive := splitDirective(key.Value)
+			if directive != "" && directive != DirectiveReplace && nodeType(value) != "array" {
+				v.report(value, "%s: %s expects a list, got %s", p, directive, nodeType(value))
+				continue
+			}
+			if ps, ok := s.Properties[name]; ok {
+				v.validate(ps, value, p)
+				continue
+			}
+			ap := s.AdditionalProperties
+			switch {
+			case ap == nil:
+			case ap.schema != nil:
+				v.validate(ap.schema, value, p)
+			case !ap.allowed:
+				v.report(key, "unknown key %s", p)
+			}
+		}
+	case kyaml.SequenceNode:
+		if s.Items == nil {
+			return
+		}
+		for i, e := range n.Content {
+			v.validate(s.Items, e, fmt.Sprintf("%s.%d", path, i))
+		}
+	case kyaml.ScalarNode:
+		if len(s.Enum) == 0 {
+			return
+		}
+		for _, e := range s.Enum {
+			if e == n.Value {
+				return
+			}
+		}
+		v.report(n, "%s: %s is not one of %s", displayPath(path), n.Value, strings.Join(s.Enum, ", "))
+	}
+}
+
+// isRefNode returns true if n is a reference to a value of another deploy,
+// which is validated when deploys are resolved
+func isRefNode(n *kyaml.Node) bool {
+	return n.Kind == kyaml.MappingNode && len(n.Content) == 2 && n.Content[0].Value == RefKey
+}
+
+// nodeType returns the JSON Schema type of a yaml node
+func nodeType(n *kyaml.Node) string {
+	switch n.Kind {
+	case kyaml.MappingNode:
+		return "object"
+	case kyaml.SequenceNode:
+		return "array"
+	}
+	switch n.ShortTag() {
+	case "!!null":
+		return "null"
+	case "!!bool":
+		return "boolean"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "document"
+	}
+	return path
+}
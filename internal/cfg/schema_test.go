@@ -0,0 +1,71 @@
+package cfg
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestSvc_Lint(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	files := map[string]string{
+		"/test/simple-ops.yml":        "labels:\n  a: b\nkustomizationPaths:\nfsslice:\n  lables: []\n",
+		"/test/environments/prod.yml": "namspace:\n  name: prod\n",
+		"/test/config/a.yml": `chart: a.tgz
+namespace:
+  create: "yes"
+chain:
+- helm
+- helms
+deploy:
+  prod:
+    kustomisations:
+      a: {}
+    extends: staging
+  staging:
+    values:
+      any:
+        thing: [1, 2]
+    namespace: $delete
+    chain$append: [helms]
+    kustomizationPaths$prepend: a
+    chart:
+      $ref: prod.b#chart
+`,
+		"/test/config/b.yml": "chart: [\n",
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(c.appFs, path, []byte(content), DefaultConfigFsPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	actual, err := c.Lint()
+	assert.NilError(t, err)
+	expected := Diagnostics{
+		{File: "config/a.yml", Line: 3, Column: 11, Message: "namespace.create: expected boolean or null, got string"},
+		{File: "config/a.yml", Line: 6, Column: 3, Message: "chain.1: helms is not one of helm, with, namespace, labels, kustomize, jsonnet"},
+		{File: "config/a.yml", Line: 9, Column: 5, Message: "unknown key deploy.prod.kustomisations"},
+		{File: "config/a.yml", Line: 17, Column: 20, Message: "deploy.staging.chain$append.0: helms is not one of helm, with, namespace, labels, kustomize, jsonnet"},
+		{File: "config/a.yml", Line: 18, Column: 33, Message: "deploy.staging.kustomizationPaths$prepend: prepend expects a list, got string"},
+		{File: "config/b.yml", Line: 1, Message: "did not find expected node content"},
+		{File: "environments/prod.yml", Line: 1, Column: 1, Message: "unknown key namspace"},
+		{File: "simple-ops.yml", Line: 5, Column: 3, Message: "unknown key fsslice.lables"},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
+
+func TestValidateFile_unknownDefinition(t *testing.T) {
+	root := &schema{Definitions: map[string]*schema{
+		"conf": {Properties: map[string]*schema{"chart": {Ref: "#/definitions/chrt"}}},
+	}}
+	_, err := validateFile(root, "conf", "config/a.yml", []byte("chart: a.tgz\n"), APIVersion)
+	assert.Error(t, err, "schema definition #/definitions/chrt not found")
+	_, err = validateFile(root, "deploy", "config/a.yml", []byte("chart: a.tgz\n"), APIVersion)
+	assert.Error(t, err, "schema definition #/definitions/deploy not found")
+}
+
+func TestDiagnostic_String(t *testing.T) {
+	d := Diagnostic{File: "config/a.yml", Line: 2, Column: 3, Message: "unknown key a"}
+	assert.Equal(t, d.String(), "config/a.yml:2:3: unknown key a")
+}
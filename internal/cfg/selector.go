@@ -0,0 +1,177 @@
+package cfg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type (
+	// Selector selects deploys by environment and component globs and by
+	// tag terms. The zero Selector selects every deploy.
+	Selector struct {
+		id          string
+		environment *regexp.Regexp
+		component   *regexp.Regexp
+		terms       []selectorTerm
+	}
+	// selectorTerm matches a tag, or a tag or label key=value, or their
+	// negation
+	selectorTerm struct {
+		tag    string
+		key    string
+		value  string
+		negate bool
+	}
+)
+
+// ParseSelector parses an id glob such as prod.* or *.ingress-nginx, where *
+// matches any characters and ? any single character, and a comma separated
+// list of tag terms, each of which must match. A term is a tag, e.g.
+// platform, a key=value tag or label, or either negated with ! or !=.
+// An empty id selects every deploy.
+func ParseSelector(id string, tags string) (Selector, error) {
+	s := Selector{id: id}
+	if id != "" {
+		env, comp, err := DeployIdParts(id)
+		if err != nil {
+			return s, fmt.Errorf("invalid selector %s", id)
+		}
+		s.environment = globRegexp(env)
+		s.component = globRegexp(comp)
+	}
+	for _, t := range strings.Split(tags, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		var term selectorTerm
+		switch {
+		case strings.Contains(t, "!="):
+			i := strings.Index(t, "!=")
+			term = selectorTerm{key: t[:i], value: t[i+2:], negate: true}
+		case strings.Contains(t, "="):
+			i := strings.Index(t, "=")
+			term = selectorTerm{key: t[:i], value: t[i+1:]}
+		case strings.HasPrefix(t, "!"):
+			term = selectorTerm{tag: t[1:], negate: true}
+		default:
+			term = selectorTerm{tag: t}
+		}
+		if term.tag == "" && term.key == "" {
+			return s, fmt.Errorf("invalid selector term %s", t)
+		}
+		s.terms = append(s.terms, term)
+	}
+	return s, nil
+}
+
+// Exact returns true if the selector is a single deploy id without globs or
+// tag terms
+func (s Selector) Exact() bool {
+	return s.id != "" && !strings.ContainsAny(s.id, "*?") && len(s.terms) == 0
+}
+
+// Empty returns true if the selector selects every deploy
+func (s Selector) Empty() bool {
+	return s.id == "" && len(s.terms) == 0
+}
+
+// Matches returns true if d is selected
+func (s Selector) Matches(d *Deploy) bool {
+	if s.environment != nil && !s.environment.MatchString(d.Environment) {
+		return false
+	}
+	if s.component != nil && !s.component.MatchString(d.Component) {
+		return false
+	}
+	for _, t := range s.terms {
+		if t.matches(d) == t.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) String() string {
+	var terms []string
+	for _, t := range s.terms {
+		terms = append(terms, t.String())
+	}
+	if len(terms) == 0 {
+		return s.id
+	}
+	return strings.TrimSpace(s.id + " " + strings.Join(terms, ","))
+}
+
+func (t selectorTerm) matches(d *Deploy) bool {
+	tag := t.tag
+	if tag == "" {
+		if v, ok := d.Labels[t.key]; ok && v == t.value {
+			return true
+		}
+		tag = t.key + "=" + t.value
+	}
+	for _, v := range d.Tags {
+		if v == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (t selectorTerm) String() string {
+	switch {
+	case t.tag != "" && t.negate:
+		return "!" + t.tag
+	case t.tag != "":
+		return t.tag
+	case t.negate:
+		return t.key + "!=" + t.value
+	default:
+		return t.key + "=" + t.value
+	}
+}
+
+// Select returns the deploys matching s
+func (ds Deploys) Select(s Selector) Deploys {
+	var selected Deploys
+	for _, d := range ds {
+		if s.Matches(d) {
+			selected = append(selected, d)
+		}
+	}
+	return selected
+}
+
+// SelectDeploys returns the configured deploys matching s, or an error if
+// none match
+func (s Svc) SelectDeploys(sel Selector) (Deploys, error) {
+	deploys, err := s.Deploys()
+	if err != nil {
+		return nil, err
+	}
+	selected := deploys.Select(sel)
+	if len(selected) == 0 && len(deploys) > 0 {
+		return nil, fmt.Errorf("no deploys match selector %s", sel)
+	}
+	return selected, nil
+}
+
+// globRegexp returns a regexp matching the glob pattern p
+func globRegexp(p string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range p {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,62 @@
+package cfg
+
+import (
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	deploys := Deploys{
+		{Environment: "prod", Component: "ingress-nginx", Tags: []string{"platform", "tier=1"}},
+		{Environment: "prod", Component: "payments/api", Labels: map[string]string{"tier": "2"}},
+		{Environment: "staging", Component: "ingress-nginx", Tags: []string{"platform", "beta"}},
+	}
+	for _, tc := range []struct {
+		id   string
+		tags string
+		e    []string
+	}{
+		{id: "", tags: "", e: []string{"prod.ingress-nginx", "prod.payments/api", "staging.ingress-nginx"}},
+		{id: "prod.*", e: []string{"prod.ingress-nginx", "prod.payments/api"}},
+		{id: "*.ingress-nginx", e: []string{"prod.ingress-nginx", "staging.ingress-nginx"}},
+		{id: "prod.payments", e: nil},
+		{id: "pro?.*/api", e: []string{"prod.payments/api"}},
+		{tags: "platform", e: []string{"prod.ingress-nginx", "staging.ingress-nginx"}},
+		{tags: "platform,!beta", e: []string{"prod.ingress-nginx"}},
+		{tags: "tier=1", e: []string{"prod.ingress-nginx"}},
+		{tags: "tier=2", e: []string{"prod.payments/api"}},
+		{id: "prod.*", tags: "tier!=1", e: []string{"prod.payments/api"}},
+	} {
+		sel, err := ParseSelector(tc.id, tc.tags)
+		assert.NilError(t, err)
+		var actual []string
+		for _, d := range deploys.Select(sel) {
+			actual = append(actual, d.Id())
+		}
+		assert.DeepEqual(t, tc.e, actual)
+	}
+}
+
+func TestParseSelector_Invalid(t *testing.T) {
+	_, err := ParseSelector("prod", "")
+	assert.Error(t, err, "invalid selector prod")
+	_, err = ParseSelector("", "=a")
+	assert.Error(t, err, "invalid selector term =a")
+}
+
+func TestSelector_Exact(t *testing.T) {
+	for _, tc := range []struct {
+		id   string
+		tags string
+		e    bool
+	}{
+		{id: "prod.a", e: true},
+		{id: "prod.*"},
+		{id: "prod.a", tags: "platform"},
+		{},
+	} {
+		sel, err := ParseSelector(tc.id, tc.tags)
+		assert.NilError(t, err)
+		assert.Equal(t, tc.e, sel.Exact())
+	}
+}
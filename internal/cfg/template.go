@@ -0,0 +1,134 @@
+package cfg
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Template delimiters of config values, distinct from helm and jsonnet
+// syntax such that those pass through unchanged.
+const (
+	TemplateLeftDelim  = "${{"
+	TemplateRightDelim = "}}"
+)
+
+// templateContext is the data config value templates are executed with.
+// Values and Labels are the merged config before templates are executed and
+// references are resolved, and namespace fields that are references are
+// empty.
+type templateContext struct {
+	Environment string
+	Component   string
+	Namespace   Namespace
+	Values      map[string]interface{}
+	Labels      map[string]interface{}
+	Params      map[string]interface{}
+}
+
+func newTemplateContext(c map[string]interface{}, environment string, component string) (templateContext, error) {
+	ctx := templateContext{Environment: environment, Component: component}
+	ctx.Values, _ = c["values"].(map[string]interface{})
+	ctx.Labels, _ = c["labels"].(map[string]interface{})
+	ctx.Params, _ = c["params"].(map[string]interface{})
+	if ns, ok := c["namespace"].(map[string]interface{}); ok {
+		for k, v := range ns {
+			if containsRef(v) {
+				ns = withoutKey(ns, k)
+			}
+		}
+		b, err := yaml.Marshal(ns)
+		if err != nil {
+			return ctx, err
+		}
+		if err := yaml.Unmarshal(b, &ctx.Namespace); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+// expandDeployTemplates executes the templates of merged deploy config c.
+// The namespace is expanded first such that other values can use the
+// expanded .Namespace.Name.
+func expandDeployTemplates(c map[string]interface{}, environment string, component string) (map[string]interface{}, error) {
+	ctx, err := newTemplateContext(c, environment, component)
+	if err != nil {
+		return nil, err
+	}
+	if ns, ok := c["namespace"].(map[string]interface{}); ok {
+		expanded, err := expandValue(ns, ctx, []string{"namespace"})
+		if err != nil {
+			return nil, err
+		}
+		c = withoutKey(c, "namespace")
+		c["namespace"] = expanded
+		if ctx, err = newTemplateContext(c, environment, component); err != nil {
+			return nil, err
+		}
+	}
+	return expandTemplates(c, ctx)
+}
+
+// expandTemplates returns a copy of m with template expressions in string
+// values executed with ctx. Templates have no functions beyond the text
+// template builtins and missing keys are errors, such that output only
+// depends on config.
+func expandTemplates(m map[string]interface{}, ctx interface{}) (map[string]interface{}, error) {
+	v, err := expandValue(m, ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]interface{}), nil
+}
+
+func expandValue(v interface{}, ctx interface{}, path []string) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, vv := range t {
+			e, err := expandValue(vv, ctx, append(path, k))
+			if err != nil {
+				return nil, err
+			}
+			out[k] = e
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, vv := range t {
+			e, err := expandValue(vv, ctx, append(path, strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = e
+		}
+		return out, nil
+	case string:
+		if !strings.Contains(t, TemplateLeftDelim) {
+			return t, nil
+		}
+		s, err := executeTemplate(t, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", JoinPath(path...), err)
+		}
+		return s, nil
+	default:
+		return v, nil
+	}
+}
+
+func executeTemplate(text string, ctx interface{}) (string, error) {
+	t, err := template.New("value").Delims(TemplateLeftDelim, TemplateRightDelim).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ctx); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
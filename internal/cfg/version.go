@@ -0,0 +1,153 @@
+package cfg
+
+import (
+	"fmt"
+	"path/filepath"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sort"
+)
+
+const (
+	// APIVersionKey is the key of the config format version of a config file
+	APIVersionKey = "apiVersion"
+	// APIVersion is the current config format version
+	APIVersion = "simple-ops/v1"
+	// LegacyAPIVersion is the version of config written before config files
+	// were versioned
+	LegacyAPIVersion = "simple-ops/v0"
+)
+
+// conversion converts config of one version to the next
+type conversion struct {
+	from string
+	to   string
+}
+
+// conversions in version order, ending with APIVersion. simple-ops/v1 reads
+// everything simple-ops/v0 did the same way.
+var conversions = []conversion{
+	{from: LegacyAPIVersion, to: APIVersion},
+}
+
+// checkAPIVersion returns an error if v is not a known config version
+func checkAPIVersion(v string) error {
+	if v == APIVersion {
+		return nil
+	}
+	for _, c := range conversions {
+		if c.from == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported %s %s, the newest supported is %s", APIVersionKey, v, APIVersion)
+}
+
+// apiVersion returns the apiVersion of config m, or def if it has none
+func apiVersion(m map[string]interface{}, def string) (string, error) {
+	v, ok := m[APIVersionKey]
+	if !ok || v == nil {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", APIVersionKey)
+	}
+	return s, checkAPIVersion(s)
+}
+
+// nodeAPIVersion returns the apiVersion of the config root node n, or def if
+// it has none
+func nodeAPIVersion(n *kyaml.Node, def string) (string, error) {
+	v := lookupNode(n, []string{APIVersionKey})
+	if isNullNode(v) {
+		return def, nil
+	}
+	if v.Kind != kyaml.ScalarNode || v.ShortTag() != "!!str" {
+		return "", fmt.Errorf("%s must be a string", APIVersionKey)
+	}
+	return v.Value, checkAPIVersion(v.Value)
+}
+
+// APIVersion returns the apiVersion of simple-ops.yml, which is the version
+// of config files without an apiVersion
+func (s Svc) APIVersion() (string, error) {
+	_, version, err := s.getGlobalConfig()
+	return version, err
+}
+
+// Migrate converts config files of older apiVersions to APIVersion in
+// place, preserving comments and key order, and returns the paths of the
+// files changed. simple-ops.yml is given the current apiVersion, which files
+// without an apiVersion then have. No file is changed if any fails to
+// convert.
+func (s Svc) Migrate() ([]string, error) {
+	unlock, err := s.lockConfig()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	_, version, err := s.getGlobalConfig()
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{GlobalConfigFile: true}
+	envPaths, err := s.getEnvironmentPaths()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range envPaths {
+		files[path] = true
+	}
+	configPaths, err := s.getConfigPaths()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range configPaths {
+		files[path] = true
+		for _, p := range s.getDefaultsPaths(path) {
+			files[p] = true
+		}
+	}
+	var ordered []string
+	for path := range files {
+		ordered = append(ordered, path)
+	}
+	sort.Strings(ordered)
+
+	var docs []*configDocument
+	var changed []string
+	for _, path := range ordered {
+		doc, err := s.readConfigDocument(filepath.Join(s.wd, path))
+		if err != nil {
+			return nil, err
+		}
+		def := version
+		if path == GlobalConfigFile {
+			def = LegacyAPIVersion
+		}
+		v, err := nodeAPIVersion(doc.root(), def)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		// files inheriting the apiVersion of simple-ops.yml are left alone
+		if v == APIVersion || (path != GlobalConfigFile && lookupNode(doc.root(), []string{APIVersionKey}) == nil) {
+			continue
+		}
+		setAPIVersion(doc.root())
+		docs = append(docs, doc)
+		changed = append(changed, path)
+	}
+	return changed, s.writeConfigDocuments(docs)
+}
+
+// setAPIVersion sets the apiVersion of the config root node n to APIVersion,
+// adding it as the first key if not present
+func setAPIVersion(n *kyaml.Node) {
+	v := &kyaml.Node{Kind: kyaml.ScalarNode, Tag: "!!str", Value: APIVersion}
+	if i := mappingValue(n, APIVersionKey); i != -1 {
+		n.Content[i] = replaceNode(n.Content[i], v)
+		return
+	}
+	k := &kyaml.Node{Kind: kyaml.ScalarNode, Tag: "!!str", Value: APIVersionKey}
+	n.Content = append([]*kyaml.Node{k, v}, n.Content...)
+}
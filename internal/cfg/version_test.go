@@ -0,0 +1,79 @@
+package cfg
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestSvc_Migrate(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	files := map[string]string{
+		"/test/simple-ops.yml": "# global config\nlabels:\n  a: b\nkustomizationPaths:\n- kustomize\n",
+		"/test/config/a.yml": `chart: a.tgz
+deploy:
+  prod:
+    # kept
+    kustomizationPaths$append: [prod]
+`,
+		"/test/config/b.yml": "apiVersion: simple-ops/v0\nchart: b.tgz\n",
+		"/test/config/c.yml": "apiVersion: simple-ops/v1\nchart: c.tgz\n",
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(c.appFs, path, []byte(content), DefaultConfigFsPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := c.Deploys()
+	assert.NilError(t, err)
+
+	changed, err := c.Migrate()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, changed, []string{"config/b.yml", GlobalConfigFile})
+
+	expected := map[string]string{
+		"/test/simple-ops.yml": "apiVersion: simple-ops/v1\n# global config\nlabels:\n  a: b\nkustomizationPaths:\n- kustomize\n",
+		"/test/config/a.yml":   files["/test/config/a.yml"],
+		"/test/config/b.yml":   "apiVersion: simple-ops/v1\nchart: b.tgz\n",
+		"/test/config/c.yml":   files["/test/config/c.yml"],
+	}
+	for path, content := range expected {
+		b, err := afero.ReadFile(c.appFs, path)
+		assert.NilError(t, err)
+		assert.Equal(t, string(b), content, path)
+	}
+
+	// migrated config renders the same deploys
+	after, err := c.Deploys()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, before, after)
+	version, err := c.APIVersion()
+	assert.NilError(t, err)
+	assert.Equal(t, version, APIVersion)
+
+	changed, err = c.Migrate()
+	assert.NilError(t, err)
+	assert.Equal(t, len(changed), 0)
+}
+
+func TestSvc_Lint_apiVersion(t *testing.T) {
+	c := NewSvc(afero.NewMemMapFs(), "/test", logrus.New())
+	files := map[string]string{
+		"/test/simple-ops.yml": "kustomizationPaths: [a]\n",
+		"/test/config/a.yml":   "apiVersion: simple-ops/v2\n",
+		"/test/config/b.yml":   "apiVersion: simple-ops/v1\npreservePaths: [a]\n",
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(c.appFs, path, []byte(content), DefaultConfigFsPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	actual, err := c.Lint()
+	assert.NilError(t, err)
+	expected := Diagnostics{
+		{File: "config/a.yml", Line: 1, Column: 13, Message: "unsupported apiVersion simple-ops/v2, the newest supported is simple-ops/v1"},
+		{File: "config/b.yml", Line: 2, Column: 1, Message: "unknown key preservePaths"},
+	}
+	assert.DeepEqual(t, expected, actual)
+}
@@ -0,0 +1,200 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/richardjennings/simple-ops/internal/cfg"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// CacheFile records the input hash of each generated deploy, relative
+	// to the working directory
+	CacheFile = ".simple-ops.cache"
+	// cacheVersion changes when the rendering of unchanged inputs changes
+	cacheVersion = "2"
+)
+
+type (
+	// inputCache is the content of CacheFile, deploy id to hashes
+	inputCache map[string]cacheEntry
+	// cacheEntry records the hashes of a generated deploy
+	cacheEntry struct {
+		// Input is the hash of everything rendering the deploy depends on
+		Input string `json:"input"`
+		// Output is the hash of the generated output of the deploy
+		Output string `json:"output"`
+	}
+)
+
+// readCache returns the input hashes of the previous generate, or an empty
+// cache if there is none or it cannot be read
+func (s Svc) readCache() inputCache {
+	c := inputCache{}
+	b, err := s.appFs.ReadFile(filepath.Join(s.wd, CacheFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.log.Warnf("ignored cache: %s", err)
+		}
+		return c
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		s.log.Warnf("ignored cache: %s", err)
+		return inputCache{}
+	}
+	return c
+}
+
+// writeCache records the hashes of the deploys of the last generate
+func (s Svc) writeCache(c inputCache) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.appFs.WriteFile(filepath.Join(s.wd, CacheFile), append(b, '\n'), defaultFilePerm)
+}
+
+// inputHash returns a hash of everything rendering d depends on, the merged
+// deploy config, its chart, with templates and kustomization paths. Deploys
+// with jsonnet are not cached, as jsonnet may import any file.
+func (s Svc) inputHash(d *cfg.Deploy) (string, error) {
+	h := sha256.New()
+	b, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	_, _ = fmt.Fprintf(h, "%s\n%s\n%s\n", cacheVersion, d.Id(), d.KubeVersion)
+	_, _ = h.Write(b)
+
+	var paths []string
+	if d.Chart != "" {
+		paths = append(paths, s.PathForChart(d.Chart))
+	}
+	for n := range d.With {
+		paths = append(paths, filepath.Join(s.wd, cfg.ResourcesPath, n)+cfg.Suffix)
+	}
+	for _, p := range d.KustomizationPaths {
+		paths = append(paths, filepath.Join(s.wd, p))
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := s.hashPath(h, p, s.wd); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// outputHash returns a hash of the generated output of d below root, its
+// deploy/<environment>/<component> directory and with path outputs
+func (s Svc) outputHash(d *cfg.Deploy, root string) (string, error) {
+	h := sha256.New()
+	paths := []string{filepath.Join(cfg.DeployPath, d.Environment, d.Component)}
+	for _, withs := range d.With {
+		for _, w := range withs {
+			if w.Path != "" {
+				paths = append(paths, filepath.Clean(w.Path))
+			}
+		}
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := s.hashPath(h, filepath.Join(root, p), root); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// hashPath writes the names relative to root and content of the file or
+// the files in the directory at path to h. A missing path is hashed as
+// missing, leaving the error to rendering.
+func (s Svc) hashPath(h hash.Hash, path string, root string) error {
+	if _, err := s.appFs.Stat(path); os.IsNotExist(err) {
+		_, _ = fmt.Fprintf(h, "missing %s\n", strings.TrimPrefix(path, root))
+		return nil
+	}
+	return s.appFs.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		_, _ = fmt.Fprintf(h, "file %s\n", strings.TrimPrefix(p, root))
+		f, err := s.appFs.Open(p)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		_, err = io.Copy(h, f)
+		return err
+	})
+}
+
+// reusePrevious copies the output of the previous generate of d into the
+// temporary directory, returning false if it no longer has the output hash
+// recorded by the previous generate, e.g. it was edited or is missing
+func (s Svc) reusePrevious(d *cfg.Deploy, output string) (bool, error) {
+	prev := filepath.Dir(s.ManifestPathForDeploy(d))
+	if ok, err := s.appFs.DirExists(prev); err != nil || !ok {
+		return false, err
+	}
+	if h, err := s.outputHash(d, s.wd); err != nil || h != output {
+		return false, err
+	}
+	var withPaths []string
+	for _, withs := range d.With {
+		for _, w := range withs {
+			if w.Path != "" {
+				withPaths = append(withPaths, w.Path)
+			}
+		}
+	}
+	// with paths of the previous output are claimed such that duplicates
+	// are detected as when rendered
+	sort.Strings(withPaths)
+	for _, p := range withPaths {
+		path, err := s.withPath(filepath.Join(s.tmp, p))
+		if err != nil {
+			return false, err
+		}
+		b, err := s.appFs.ReadFile(filepath.Join(s.wd, p))
+		if err != nil {
+			return false, err
+		}
+		if err := s.appFs.MkdirAll(filepath.Dir(path), defaultDirPerm); err != nil {
+			return false, err
+		}
+		if err := s.appFs.WriteFile(path, b, defaultFilePerm); err != nil {
+			return false, err
+		}
+	}
+	return true, s.copyDir(prev, s.pathForTmpComponent(d))
+}
+
+// copyDir copies the files in from to to
+func (s Svc) copyDir(from string, to string) error {
+	return s.appFs.Walk(from, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(to, strings.TrimPrefix(p, from))
+		if info.IsDir() {
+			return s.appFs.MkdirAll(dest, defaultDirPerm)
+		}
+		b, err := s.appFs.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return s.appFs.WriteFile(dest, b, defaultFilePerm)
+	})
+}
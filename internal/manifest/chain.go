@@ -8,6 +8,7 @@ import (
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
 	lbs "sigs.k8s.io/kustomize/api/filters/labels"
 	ns "sigs.k8s.io/kustomize/api/filters/namespace"
 
@@ -19,6 +20,25 @@ import (
 
 type chainFn func(deploy *cfg.Deploy, man *bytes.Buffer, crds *bytes.Buffer, s Svc) error
 
+const (
+	// LabelsFsSlice is the fsslice key of the field specs the labels action
+	// sets labels at
+	LabelsFsSlice = "labels"
+	// NamespaceFsSlice is the fsslice key of the field specs the namespace
+	// action sets the namespace at, in addition to metadata/namespace
+	NamespaceFsSlice = "namespace"
+)
+
+// defaultFsSlices are used by actions when the deploy does not configure
+// the fsslice key
+var defaultFsSlices = map[string]types.FsSlice{
+	LabelsFsSlice: {
+		{Path: "metadata/labels", CreateIfNotPresent: true},
+		{Path: "spec/template/metadata/labels", CreateIfNotPresent: false},
+	},
+	NamespaceFsSlice: {},
+}
+
 var actions = map[string]chainFn{
 	"helm":      helm,
 	"with":      with,
@@ -63,19 +83,24 @@ func jsonnetAction(deploy *cfg.Deploy, man *bytes.Buffer, _ *bytes.Buffer, s Svc
 	return s.jsonnetDeploy(deploy, nil)
 }
 
-// Labels action templates namespace config
+// fsSlice returns the field specs of the deploy fsslice key k, or the
+// defaults if not configured
+func fsSlice(deploy *cfg.Deploy, k string) types.FsSlice {
+	if fs, ok := deploy.FsSlice[k]; ok {
+		return fs
+	}
+	return defaultFsSlices[k]
+}
+
+// Labels action templates labels config
 func labels(deploy *cfg.Deploy, man *bytes.Buffer, _ *bytes.Buffer, _ Svc) error {
 	if len(deploy.Labels) == 0 {
 		return nil
 	}
 	buf := bytes.Buffer{}
-	fslice := types.FsSlice{
-		{Path: "metadata/labels", CreateIfNotPresent: true},
-		{Path: "spec/template/metadata/labels", CreateIfNotPresent: false},
-	}
 	err := kio.Pipeline{
 		Inputs:  []kio.Reader{&kio.ByteReader{Reader: man}},
-		Filters: []kio.Filter{lbs.Filter{Labels: deploy.Labels, FsSlice: fslice}},
+		Filters: []kio.Filter{lbs.Filter{Labels: deploy.Labels, FsSlice: fsSlice(deploy, LabelsFsSlice)}},
 		Outputs: []kio.Writer{kio.ByteWriter{Writer: &buf}},
 	}.Execute()
 	*man = buf
@@ -90,7 +115,7 @@ func namespace(deploy *cfg.Deploy, man *bytes.Buffer, _ *bytes.Buffer, _ Svc) er
 	buf := bytes.Buffer{}
 	err := kio.Pipeline{
 		Inputs:  []kio.Reader{&kio.ByteReader{Reader: man}},
-		Filters: []kio.Filter{ns.Filter{Namespace: deploy.Namespace.Name, FsSlice: types.FsSlice{}}},
+		Filters: []kio.Filter{ns.Filter{Namespace: deploy.Namespace.Name, FsSlice: fsSlice(deploy, NamespaceFsSlice)}},
 		Outputs: []kio.Writer{kio.ByteWriter{Writer: &buf}},
 	}.Execute()
 	*man = buf
@@ -131,6 +156,11 @@ func helm(deploy *cfg.Deploy, man *bytes.Buffer, crds *bytes.Buffer, s Svc) erro
 	client.CreateNamespace = false
 	client.IncludeCRDs = false
 	client.SkipCRDs = true
+	if deploy.KubeVersion != "" {
+		if client.KubeVersion, err = chartutil.ParseKubeVersion(deploy.KubeVersion); err != nil {
+			return fmt.Errorf("deploy %s: %s", deploy.Id(), err)
+		}
+	}
 
 	// render the helm chart
 	rel, err := client.Run(chrt, deploy.Values)
@@ -22,6 +22,7 @@ import (
 	"sigs.k8s.io/kustomize/kyaml/filesys"
 	"sort"
 	"strings"
+	"sync"
 )
 
 const (
@@ -31,11 +32,30 @@ const (
 
 type (
 	Svc struct {
-		appFs  afero.Afero
-		client *action.Install
-		wd     string
-		tmp    string
-		log    *logrus.Logger
+		appFs       afero.Afero
+		client      *action.Install
+		wd          string
+		tmp         string
+		log         *logrus.Logger
+		concurrency int
+		cache       bool
+		gen         *generation
+	}
+	// generation is the state shared by the deploys rendered concurrently
+	// by a generate
+	generation struct {
+		mu        sync.Mutex
+		withPaths map[string]bool
+		hashes    inputCache
+	}
+	// kustomizationFs serves the kustomization.yaml of a single deploy
+	// from memory and otherwise reads from disk, such that deploys
+	// kustomized concurrently in the same build context do not share a
+	// scratch file
+	kustomizationFs struct {
+		filesys.FileSystem
+		path    string
+		content []byte
 	}
 )
 
@@ -48,7 +68,26 @@ func NewSvc(fs afero.Fs, wd string, log *logrus.Logger) *Svc {
 	client.ClientOnly = true
 	client.IncludeCRDs = true
 
-	return &Svc{appFs: afero.Afero{Fs: fs}, wd: wd, client: client, log: log}
+	return &Svc{appFs: afero.Afero{Fs: fs}, wd: wd, client: client, log: log, concurrency: 1, cache: true, gen: newGeneration()}
+}
+
+// SetConcurrency sets the number of deploys rendered in parallel, at least 1
+func (s *Svc) SetConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.concurrency = n
+}
+
+// SetCache sets whether Generate reuses the output of deploys whose inputs
+// are unchanged since the previous generate. Verify never does. The hashes
+// of the deploys generated are recorded either way.
+func (s *Svc) SetCache(enabled bool) {
+	s.cache = enabled
+}
+
+func newGeneration() *generation {
+	return &generation{withPaths: make(map[string]bool), hashes: inputCache{}}
 }
 
 // Verify generates manifests in a temporary directory and
@@ -58,7 +97,7 @@ func NewSvc(fs afero.Fs, wd string, log *logrus.Logger) *Svc {
 // file generated via with => path.
 func (s Svc) Verify(deploys cfg.Deploys) (bool, error) {
 	var err error
-	err = s.doGenerate(deploys)
+	err = s.doGenerate(deploys, nil)
 	if err != nil {
 		return false, err
 	}
@@ -84,12 +123,59 @@ func (s Svc) Verify(deploys cfg.Deploys) (bool, error) {
 	return tmpHash == depHash, nil
 }
 
+// VerifyDeploys returns true if the generated output of each of deploys
+// matches its deploy/<environment>/<component> directory. Other deploy
+// directories are not compared.
+func (s Svc) VerifyDeploys(deploys cfg.Deploys) (bool, error) {
+	var err error
+	err = s.doGenerate(deploys, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if s.tmp != "" {
+			err = s.appFs.RemoveAll(s.tmp)
+		}
+	}()
+
+	cmp := hash.NewSvc(s.appFs.Fs, s.log)
+	consistent := true
+	for _, d := range deploys {
+		tmpHash, err := s.dirHash(cmp, s.pathForTmpComponent(d))
+		if err != nil {
+			return false, err
+		}
+		depHash, err := s.dirHash(cmp, filepath.Dir(s.ManifestPathForDeploy(d)))
+		if err != nil {
+			return false, err
+		}
+		if tmpHash != depHash {
+			s.log.Errorf("deploy %s is not consistent with configuration", d.Id())
+			consistent = false
+		}
+	}
+	return consistent, nil
+}
+
+// dirHash returns the hash of the files in dir, or an empty string if dir
+// does not exist
+func (s Svc) dirHash(cmp *hash.Svc, dir string) (string, error) {
+	if ok, err := s.appFs.DirExists(dir); err != nil || !ok {
+		return "", err
+	}
+	return cmp.SHA256(dir)
+}
+
 // Generate generates manifests in a temporary directory and
 // copies the content into the deployment directory if the generation
 // process completes successfully.
 func (s Svc) Generate(deploys cfg.Deploys) error {
 	var err error
-	err = s.doGenerate(deploys)
+	cache := inputCache{}
+	if s.cache {
+		cache = s.readCache()
+	}
+	err = s.doGenerate(deploys, cache)
 	defer func() {
 		if err != nil {
 			err = s.appFs.RemoveAll(s.tmp)
@@ -98,10 +184,81 @@ func (s Svc) Generate(deploys cfg.Deploys) error {
 	if err != nil {
 		return err
 	}
-	err = s.renameDirectory(s.tmp, s.wd)
+	if err = s.renameDirectory(s.tmp, s.wd); err != nil {
+		return err
+	}
 	s.log.Debugf("performed rename on %s to %s", s.tmp, s.wd)
 
-	return err
+	return s.writeCache(s.gen.hashes)
+}
+
+// GenerateDeploys generates the manifests of deploys in a temporary
+// directory and, if generation completes successfully, replaces only their
+// deploy/<environment>/<component> directories and with path outputs,
+// leaving the output of other deploys untouched. The output of disabled
+// deploys is removed.
+func (s Svc) GenerateDeploys(deploys cfg.Deploys) error {
+	cache := inputCache{}
+	if s.cache {
+		cache = s.readCache()
+	}
+	err := s.doGenerate(deploys, cache)
+	defer func() {
+		_ = s.appFs.RemoveAll(s.tmp)
+	}()
+	if err != nil {
+		return err
+	}
+	for _, d := range deploys {
+		dest := filepath.Dir(s.ManifestPathForDeploy(d))
+		if err := s.appFs.RemoveAll(dest); err != nil {
+			return err
+		}
+		if d.Disabled {
+			continue
+		}
+		if err := s.copyDir(s.pathForTmpComponent(d), dest); err != nil {
+			return err
+		}
+		for _, withs := range d.With {
+			for _, w := range withs {
+				if w.Path == "" {
+					continue
+				}
+				if err := s.copyWithPath(w.Path); err != nil {
+					return err
+				}
+			}
+		}
+		s.log.Debugf("replaced output of deploy %s", d.Id())
+	}
+	// hashes of deploys not generated are kept
+	previous := s.readCache()
+	for _, d := range deploys {
+		delete(previous, d.Id())
+	}
+	for id, h := range s.gen.hashes {
+		previous[id] = h
+	}
+	return s.writeCache(previous)
+}
+
+// copyWithPath copies the with path output p, relative to the working
+// directory, from the temporary directory if it was rendered
+func (s Svc) copyWithPath(p string) error {
+	src := filepath.Join(s.tmp, p)
+	if ok, err := s.appFs.Exists(src); err != nil || !ok {
+		return err
+	}
+	b, err := s.appFs.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(s.wd, p)
+	if err := s.appFs.MkdirAll(filepath.Dir(dest), defaultDirPerm); err != nil {
+		return err
+	}
+	return s.appFs.WriteFile(dest, b, defaultFilePerm)
 }
 
 // Pull adds a tgz chart to charts from repoUrl with chartRef and version
@@ -144,18 +301,95 @@ func (s Svc) PullAddConfig(chartRef string, version string) error {
 	return nil
 }
 
-func (s *Svc) doGenerate(deploys cfg.Deploys) error {
+// doGenerate renders deploys to a temporary directory. The output of deploys
+// whose input hash matches cache is reused, and the input hashes are
+// recorded, unless cache is nil.
+func (s *Svc) doGenerate(deploys cfg.Deploys, cache inputCache) error {
 	var err error
 	s.tmp, err = s.appFs.TempDir("", "simple-ops-")
 	if err != nil {
 		return err
 	}
-	for _, deploy := range deploys {
-		if err := s.chainDeploy(deploy); err != nil {
+	if err = s.appFs.MkdirAll(filepath.Join(s.tmp, cfg.DeployPath), defaultDirPerm); err != nil {
+		return err
+	}
+	s.gen = newGeneration()
+
+	// deploys are rendered by a pool of workers, each to its own directory,
+	// such that the output does not depend on the order they complete.
+	// No further deploys are started once one fails.
+	errs := make([]error, len(deploys))
+	jobs := make(chan int)
+	failed := make(chan struct{})
+	var fail sync.Once
+	var wg sync.WaitGroup
+	workers := s.concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if errs[i] = s.generateDeploy(deploys[i], cache); errs[i] != nil {
+					fail.Do(func() { close(failed) })
+				}
+			}
+		}()
+	}
+dispatch:
+	for i, deploy := range deploys {
+		// disabled deploys are not rendered, removing any previous output
+		if deploy.Disabled {
+			s.log.Debugf("skipped disabled deploy %s", deploy.Id())
+			continue
+		}
+		select {
+		case jobs <- i:
+		case <-failed:
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	// the error of the first failed deploy in order is returned
+	for _, err := range errs {
+		if err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
+// generateDeploy renders d, or reuses its previous output if its input hash
+// and the output on disk are unchanged since the previous generate
+func (s Svc) generateDeploy(d *cfg.Deploy, cache inputCache) error {
+	if cache == nil || len(d.Jsonnet) > 0 {
+		return s.chainDeploy(d)
+	}
+	h, err := s.inputHash(d)
+	if err != nil {
+		return err
+	}
+	reused := false
+	if prev, ok := cache[d.Id()]; ok && prev.Input == h {
+		if reused, err = s.reusePrevious(d, prev.Output); err != nil {
+			return err
+		}
+	}
+	if reused {
+		s.log.Debugf("reused output of unchanged deploy %s", d.Id())
+	} else if err := s.chainDeploy(d); err != nil {
+		return err
+	}
+	out, err := s.outputHash(d, s.tmp)
+	if err != nil {
+		return err
+	}
+	s.gen.mu.Lock()
+	s.gen.hashes[d.Id()] = cacheEntry{Input: h, Output: out}
+	s.gen.mu.Unlock()
 	return nil
 }
 
@@ -282,10 +516,14 @@ func (s Svc) withPath(path string) (string, error) {
 	if !strings.HasPrefix(path, s.tmp) {
 		return "", errors.New("path cannot be outside working directory")
 	}
-	// error if a duplicate path
-	if _, err := s.appFs.Stat(path); err == nil {
+	// error if a duplicate path, claimed by another deploy or an existing
+	// file
+	s.gen.mu.Lock()
+	defer s.gen.mu.Unlock()
+	if _, err := s.appFs.Stat(path); err == nil || s.gen.withPaths[path] {
 		return "", fmt.Errorf("with template path duplicate: %s", strings.TrimPrefix(path, s.tmp))
 	}
+	s.gen.withPaths[path] = true
 	return path, nil
 }
 
@@ -320,6 +558,9 @@ func (Svc) createNamespaceManifest(deploy *cfg.Deploy) ([]byte, error) {
 }
 
 func (s Svc) copyKustomizationPaths(d *cfg.Deploy) error {
+	// paths shared by deploys are copied once
+	s.gen.mu.Lock()
+	defer s.gen.mu.Unlock()
 	for _, p := range d.KustomizationPaths {
 		dest := filepath.Join(s.tmp, p)
 		src := filepath.Join(s.wd, p)
@@ -333,25 +574,28 @@ func (s Svc) copyKustomizationPaths(d *cfg.Deploy) error {
 	return nil
 }
 
+// kustomizeDeploy runs each kustomization of d over its manifest with the
+// temporary directory as the build context, such that relative paths in
+// kustomizations refer to copied kustomizationPaths
 func (s Svc) kustomizeDeploy(d *cfg.Deploy) error {
-	kfs := filesys.MakeFsOnDisk()
+	disk := filesys.MakeFsOnDisk()
 	opts := krusty.MakeDefaultOptions()
 	krust := krusty.MakeKustomizer(opts)
 	p := s.pathForTmpComponent(d)
-	file := filepath.Join(s.tmp, "kustomization.yaml")
+	root, _, err := disk.CleanedAbs(s.tmp)
+	if err != nil {
+		return err
+	}
 	manifest := filepath.Join(p, "manifest.yaml")
 	for _, k := range d.Kustomizations {
 		k.Resources = []string{
 			manifest,
 		}
-		// write kustomization
 		b, err := yaml.Marshal(k)
 		if err != nil {
 			return err
 		}
-		if err := s.appFs.WriteFile(file, b, defaultFilePerm); err != nil {
-			return err
-		}
+		kfs := kustomizationFs{FileSystem: disk, path: root.Join("kustomization.yaml"), content: b}
 		res, err := krust.Run(kfs, s.tmp)
 		if err != nil {
 			return err
@@ -363,14 +607,32 @@ func (s Svc) kustomizeDeploy(d *cfg.Deploy) error {
 		if err := s.appFs.WriteFile(manifest, b, defaultFilePerm); err != nil {
 			return err
 		}
-		// delete kustomization file
-		if err := s.appFs.Remove(file); err != nil {
-			return err
-		}
 	}
 	return nil
 }
 
+func (k kustomizationFs) Exists(path string) bool {
+	return path == k.path || k.FileSystem.Exists(path)
+}
+
+func (k kustomizationFs) IsDir(path string) bool {
+	return path != k.path && k.FileSystem.IsDir(path)
+}
+
+func (k kustomizationFs) ReadFile(path string) ([]byte, error) {
+	if path == k.path {
+		return k.content, nil
+	}
+	return k.FileSystem.ReadFile(path)
+}
+
+func (k kustomizationFs) CleanedAbs(path string) (filesys.ConfirmedDir, string, error) {
+	if path == k.path {
+		return filesys.ConfirmedDir(filepath.Dir(path)), filepath.Base(path), nil
+	}
+	return k.FileSystem.CleanedAbs(path)
+}
+
 func (s Svc) PathForChart(p string) string {
 	return s.wd + string(os.PathSeparator) + cfg.ChartsPath + string(os.PathSeparator) + p
 }
@@ -393,6 +655,9 @@ func (s Svc) renameDirectory(from string, to string) error {
 		}
 		return cp.Copy(from, to, cp.Options{AddPermission: defaultFilePerm, PreserveOwner: true, OnSymlink: onSymLink})
 	case *afero.MemMapFs:
+		if err := s.appFs.RemoveAll(filepath.Join(to, cfg.DeployPath)); err != nil {
+			return err
+		}
 		// move files (not a fan of this)
 		// string prefix should be ok because we are inside path already
 		if err := s.appFs.Walk(from, func(path string, info fs.FileInfo, err error) error {
@@ -1,13 +1,17 @@
 package manifest
 
 import (
+	"encoding/json"
 	"github.com/google/go-jsonnet"
 	"github.com/richardjennings/simple-ops/internal/cfg"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
 	"gotest.tools/assert"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/resid"
 	"testing"
 )
 
@@ -145,6 +149,75 @@ metadata:
 	assert.Equal(t, "metadata:\n  name: path\n", string(withPath))
 }
 
+func TestSvc_GenerateVerify_disabled(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	m := NewSvc(fs, "/test", logrus.New())
+
+	setupWithTestChart(t, fs)
+
+	// previous output of the deploy to be disabled
+	if err := afero.WriteFile(fs, "/test/deploy/env/disabled/manifest.yaml", []byte("test:\n"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	deploys := cfg.Deploys{
+		&cfg.Deploy{
+			Chart:       "test-0.1.0.tgz",
+			Environment: "env",
+			Component:   "disabled",
+			Disabled:    true,
+			Chain:       []string{"helm"},
+		},
+		&cfg.Deploy{
+			Chart:       "test-0.1.0.tgz",
+			Environment: "env",
+			Component:   "test",
+			Chain:       []string{"helm"},
+		},
+	}
+	valid, err := m.Verify(deploys)
+	assert.NilError(t, err)
+	assert.Equal(t, valid, false)
+
+	assert.NilError(t, m.Generate(deploys))
+	_, err = fs.Stat("/test/deploy/env/disabled")
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = fs.Stat("/test/deploy/env/test/manifest.yaml")
+	assert.NilError(t, err)
+
+	valid, err = m.Verify(deploys)
+	assert.NilError(t, err)
+	assert.Equal(t, valid, true)
+}
+
+func TestSvc_VerifyDeploys(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	m := NewSvc(fs, "/test", logrus.New())
+
+	setupWithTestChart(t, fs)
+
+	deploy := &cfg.Deploy{
+		Chart:       "test-0.1.0.tgz",
+		Environment: "env",
+		Component:   "test",
+		Chain:       []string{"helm"},
+	}
+	assert.NilError(t, m.Generate(cfg.Deploys{deploy}))
+	// output of deploys not selected is not compared
+	if err := afero.WriteFile(fs, "/test/deploy/env/other/manifest.yaml", []byte("test:\n"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	valid, err := m.VerifyDeploys(cfg.Deploys{deploy})
+	assert.NilError(t, err)
+	assert.Equal(t, valid, true)
+
+	if err := afero.WriteFile(fs, "/test/deploy/env/test/manifest.yaml", []byte("test:\n"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	valid, err = m.VerifyDeploys(cfg.Deploys{deploy})
+	assert.NilError(t, err)
+	assert.Equal(t, valid, false)
+}
+
 func TestSvc_chainDeploy(t *testing.T) {
 	fs := afero.NewMemMapFs()
 	m := NewSvc(fs, "/test", logrus.New())
@@ -172,6 +245,86 @@ test:
 	assert.Equal(t, string(actual), expected)
 }
 
+func TestSvc_chainDeploy_fsslice(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	m := NewSvc(fs, "/test", logrus.New())
+	m.tmp = "/test"
+	setupWithTestChart(t, fs)
+	cronJob := `apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec: {}
+`
+	if err := afero.WriteFile(fs, "/test/resources/cronjob.yml", []byte(cronJob), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cronJobFs := func(path string) types.FieldSpec {
+		return types.FieldSpec{Gvk: resid.Gvk{Kind: "CronJob"}, Path: path, CreateIfNotPresent: true}
+	}
+	deploy := &cfg.Deploy{
+		Environment: "env",
+		Component:   "test",
+		Namespace:   cfg.Namespace{Name: "ns", Inject: true},
+		Labels:      map[string]string{"a": "b"},
+		With:        cfg.Withs{"cronjob": {"job": cfg.With{}}},
+		FsSlice: map[string][]types.FieldSpec{
+			"labels": {
+				{Path: "metadata/labels", CreateIfNotPresent: true},
+				cronJobFs("spec/jobTemplate/spec/template/metadata/labels"),
+			},
+			"namespace": {cronJobFs("spec/jobTemplate/metadata/namespace")},
+		},
+		Chain: []string{"with", "namespace", "labels"},
+	}
+	assert.NilError(t, m.chainDeploy(deploy))
+	actual, err := afero.ReadFile(fs, filepath.Join(m.tmp, "deploy/env/test/manifest.yaml"))
+	assert.NilError(t, err)
+	expected := `# Source: simple-ops with cronjob.yml
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: job
+  namespace: ns
+  labels:
+    a: b
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec: {}
+        metadata:
+          labels:
+            a: b
+    metadata:
+      namespace: ns
+`
+	assert.Equal(t, string(actual), expected)
+
+	// without fsslice the defaults apply
+	deploy.FsSlice = nil
+	assert.NilError(t, m.chainDeploy(deploy))
+	actual, err = afero.ReadFile(fs, filepath.Join(m.tmp, "deploy/env/test/manifest.yaml"))
+	assert.NilError(t, err)
+	expected = `# Source: simple-ops with cronjob.yml
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: job
+  namespace: ns
+  labels:
+    a: b
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec: {}
+`
+	assert.Equal(t, string(actual), expected)
+}
+
 func TestSvc_ManifestPathForDeploy(t *testing.T) {
 	fs := afero.NewMemMapFs()
 	m := NewSvc(fs, "/test", logrus.New())
@@ -303,3 +456,236 @@ local b = 2;
 `
 	assert.Equal(t, string(b), expected)
 }
+
+func TestSvc_Generate_concurrency(t *testing.T) {
+	wd := t.TempDir()
+	fs := afero.NewOsFs()
+	m := NewSvc(fs, wd, logrus.New())
+	m.SetConcurrency(4)
+
+	if err := fs.MkdirAll(filepath.Join(wd, "resources"), cfg.DefaultConfigDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, filepath.Join(wd, "resources/thing.yml"), []byte("kind: ConfigMap\napiVersion: v1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var deploys cfg.Deploys
+	for _, c := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		deploys = append(deploys, &cfg.Deploy{
+			Environment: "env",
+			Component:   c,
+			With:        cfg.Withs{"thing": {c: cfg.With{}}},
+			Kustomizations: map[string]*types.Kustomization{
+				"labels": {CommonLabels: map[string]string{"component": c}},
+			},
+			Chain: []string{"with", "kustomize"},
+		})
+	}
+	assert.NilError(t, m.Generate(deploys))
+	for _, d := range deploys {
+		b, err := afero.ReadFile(fs, m.ManifestPathForDeploy(d))
+		assert.NilError(t, err)
+		expected := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  labels:\n    component: " + d.Component + "\n  name: " + d.Component + "\n"
+		assert.Equal(t, string(b), expected)
+	}
+	_, err := fs.Stat(filepath.Join(wd, "kustomization.yaml"))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestSvc_Generate_concurrentDuplicateWithPath(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	m := NewSvc(fs, "/test", logrus.New())
+	m.SetConcurrency(4)
+	setupWithTestChart(t, fs)
+	if err := afero.WriteFile(fs, "/test/resources/file.yml", []byte("metadata:\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var deploys cfg.Deploys
+	for _, c := range []string{"a", "b", "c", "d"} {
+		deploys = append(deploys, &cfg.Deploy{
+			Environment: "env",
+			Component:   c,
+			With:        cfg.Withs{"file": {"app": cfg.With{Path: "apps/app.yaml"}}},
+			Chain:       []string{"with"},
+		})
+	}
+	assert.Error(t, m.Generate(deploys), "with template path duplicate: /apps/app.yaml")
+}
+
+func TestSvc_Generate_cache(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	m := NewSvc(fs, "/test", logrus.New())
+	setupWithTestChart(t, fs)
+	deploy := &cfg.Deploy{
+		Chart:       "test-0.1.0.tgz",
+		Environment: "env",
+		Component:   "test",
+		Chain:       []string{"helm"},
+	}
+	assert.NilError(t, m.Generate(cfg.Deploys{deploy}))
+	b, err := afero.ReadFile(fs, "/test/"+CacheFile)
+	assert.NilError(t, err)
+	cache := inputCache{}
+	assert.NilError(t, json.Unmarshal(b, &cache))
+	h, err := m.inputHash(deploy)
+	assert.NilError(t, err)
+	out, err := m.outputHash(deploy, "/test")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cache, inputCache{"env.test": {Input: h, Output: out}})
+
+	// the output of an unchanged deploy is reused rather than rendered, shown
+	// by recording the input hash of an unloadable chart
+	chart := m.PathForChart(deploy.Chart)
+	if err := fs.Rename(chart, chart+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, chart, []byte("not a chart"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	h, err = m.inputHash(deploy)
+	assert.NilError(t, err)
+	if err := m.writeCache(inputCache{"env.test": {Input: h, Output: out}}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NilError(t, m.Generate(cfg.Deploys{deploy}))
+	if err := fs.Rename(chart+".moved", chart); err != nil {
+		t.Fatal(err)
+	}
+
+	// edited output is rendered again, as verify would not pass
+	manifest := m.ManifestPathForDeploy(deploy)
+	if err := afero.WriteFile(fs, manifest, []byte("edited:\n"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	assert.NilError(t, m.Generate(cfg.Deploys{deploy}))
+	b, err = afero.ReadFile(fs, manifest)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "---\n# Source: test/templates/test.yaml\ntest:\n")
+	valid, err := m.Verify(cfg.Deploys{deploy})
+	assert.NilError(t, err)
+	assert.Equal(t, valid, true)
+
+	// a changed input is rendered
+	deploy.Values = map[string]interface{}{"test": "changed"}
+	assert.NilError(t, m.Generate(cfg.Deploys{deploy}))
+	b, err = afero.ReadFile(fs, manifest)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "---\n# Source: test/templates/test.yaml\ntest: changed\n")
+
+	// as is every deploy without the cache
+	if err := afero.WriteFile(fs, manifest, []byte("reused:\n"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	m.SetCache(false)
+	assert.NilError(t, m.Generate(cfg.Deploys{deploy}))
+	b, err = afero.ReadFile(fs, manifest)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "---\n# Source: test/templates/test.yaml\ntest: changed\n")
+}
+
+func TestSvc_Generate_cacheJsonnet(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	m := NewSvc(fs, "/test", logrus.New())
+	deploy := &cfg.Deploy{
+		Environment: "env",
+		Component:   "test",
+		Jsonnet:     map[string]*cfg.Jsonnet{"test": {Inline: "[1]"}},
+		Chain:       []string{"jsonnet"},
+	}
+	assert.NilError(t, m.Generate(cfg.Deploys{deploy}))
+	b, err := afero.ReadFile(fs, "/test/"+CacheFile)
+	assert.NilError(t, err)
+	cache := inputCache{}
+	assert.NilError(t, json.Unmarshal(b, &cache))
+	assert.Equal(t, len(cache), 0)
+}
+
+func TestSvc_inputHash(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	m := NewSvc(fs, "/test", logrus.New())
+	setupWithTestChart(t, fs)
+	if err := afero.WriteFile(fs, "/test/resources/file.yml", []byte("metadata:\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	deploy := &cfg.Deploy{
+		Chart:       "test-0.1.0.tgz",
+		Environment: "env",
+		Component:   "test",
+		With:        cfg.Withs{"file": {"a": cfg.With{}}},
+	}
+	before, err := m.inputHash(deploy)
+	assert.NilError(t, err)
+	if err := afero.WriteFile(fs, "/test/resources/file.yml", []byte("metadata:\n  labels: {}\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	after, err := m.inputHash(deploy)
+	assert.NilError(t, err)
+	assert.Assert(t, before != after)
+
+	deploy.Environment = "other"
+	other, err := m.inputHash(deploy)
+	assert.NilError(t, err)
+	assert.Assert(t, after != other)
+}
+
+func TestSvc_GenerateDeploys(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	m := NewSvc(fs, "/test", logrus.New())
+	setupWithTestChart(t, fs)
+	if err := afero.WriteFile(fs, "/test/resources/file.yml", []byte("metadata:\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	selected := &cfg.Deploy{
+		Chart:       "test-0.1.0.tgz",
+		Environment: "env",
+		Component:   "selected",
+		With:        cfg.Withs{"file": {"app": cfg.With{Path: "apps/selected.yaml"}}},
+		Chain:       []string{"helm", "with"},
+	}
+	other := &cfg.Deploy{
+		Chart:       "test-0.1.0.tgz",
+		Environment: "env",
+		Component:   "other",
+		Chain:       []string{"helm"},
+	}
+	assert.NilError(t, m.Generate(cfg.Deploys{selected, other}))
+	if err := afero.WriteFile(fs, "/test/deploy/env/other/manifest.yaml", []byte("untouched:\n"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/test/deploy/unmanaged.yaml", []byte("untouched:\n"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/test/deploy/env/selected/stale.yaml", []byte("stale:\n"), 0655); err != nil {
+		t.Fatal(err)
+	}
+
+	selected.Values = map[string]interface{}{"test": "changed"}
+	selected.With["file"]["app"] = cfg.With{Path: "apps/selected.yaml", Values: map[string]interface{}{"spec": "changed"}}
+	assert.NilError(t, m.GenerateDeploys(cfg.Deploys{selected}))
+
+	for path, expected := range map[string]string{
+		"/test/deploy/env/selected/manifest.yaml": "---\n# Source: test/templates/test.yaml\ntest: changed\n",
+		"/test/apps/selected.yaml":                "metadata:\n  name: app\nspec: changed\n",
+		"/test/deploy/env/other/manifest.yaml":    "untouched:\n",
+		"/test/deploy/unmanaged.yaml":             "untouched:\n",
+	} {
+		b, err := afero.ReadFile(fs, path)
+		assert.NilError(t, err)
+		assert.Equal(t, string(b), expected, path)
+	}
+	_, err := fs.Stat("/test/deploy/env/selected/stale.yaml")
+	assert.Assert(t, os.IsNotExist(err))
+
+	// input hashes of deploys not generated are kept
+	b, err := afero.ReadFile(fs, "/test/"+CacheFile)
+	assert.NilError(t, err)
+	cache := inputCache{}
+	assert.NilError(t, json.Unmarshal(b, &cache))
+	assert.Equal(t, len(cache), 2)
+
+	// the output of a selected disabled deploy is removed
+	other.Disabled = true
+	assert.NilError(t, m.GenerateDeploys(cfg.Deploys{other}))
+	_, err = fs.Stat("/test/deploy/env/other")
+	assert.Assert(t, os.IsNotExist(err))
+}
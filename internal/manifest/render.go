@@ -0,0 +1,106 @@
+package manifest
+
+import (
+	"fmt"
+	"github.com/richardjennings/simple-ops/internal/cfg"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RenderOptions select the output of Render
+type RenderOptions struct {
+	// CRDs includes the CRDs of the chart
+	CRDs bool
+	// WithPaths includes with templates rendered to a path
+	WithPaths bool
+	// Until stops the chain after the named step
+	Until string
+}
+
+// Render runs the chain of d in a temporary directory outside the working
+// directory and writes the resulting manifest to w, leaving the working
+// directory unchanged
+func (s Svc) Render(d *cfg.Deploy, opts RenderOptions, w io.Writer) error {
+	if d.Disabled {
+		return fmt.Errorf("deploy %s is disabled", d.Id())
+	}
+	deploy := *d
+	if opts.Until != "" {
+		i := indexOf(d.Chain, opts.Until)
+		if i == -1 {
+			return fmt.Errorf("step %s is not in the chain of %s: %s", opts.Until, d.Id(), strings.Join(d.Chain, ", "))
+		}
+		deploy.Chain = d.Chain[:i+1]
+	}
+	var err error
+	if s.tmp, err = s.appFs.TempDir("", "simple-ops-"); err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.appFs.RemoveAll(s.tmp)
+	}()
+	s.gen = newGeneration()
+	if err := s.chainDeploy(&deploy); err != nil {
+		return err
+	}
+
+	if err := s.copyRendered(w, s.pathForTmpManifest(&deploy), ""); err != nil {
+		return err
+	}
+	if opts.CRDs {
+		if err := s.copyRendered(w, s.pathForTmpCRDs(&deploy), "# Source: simple-ops crds\n"); err != nil {
+			return err
+		}
+	}
+	if opts.WithPaths {
+		var paths []string
+		for _, withs := range deploy.With {
+			for _, with := range withs {
+				if with.Path != "" {
+					paths = append(paths, with.Path)
+				}
+			}
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			if err := s.copyRendered(w, filepath.Join(s.tmp, p), fmt.Sprintf("# Source: simple-ops with path %s\n", p)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyRendered writes the file at path, if rendered, to w as a yaml
+// document headed by header
+func (s Svc) copyRendered(w io.Writer, path string, header string) error {
+	b, err := s.appFs.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if header != "" {
+		if !strings.HasPrefix(string(b), "---\n") {
+			header = "---\n" + header
+		}
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func indexOf(l []string, v string) int {
+	for i, e := range l {
+		if e == v {
+			return i
+		}
+	}
+	return -1
+}
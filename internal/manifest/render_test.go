@@ -0,0 +1,56 @@
+package manifest
+
+import (
+	"bytes"
+	"github.com/richardjennings/simple-ops/internal/cfg"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"gotest.tools/assert"
+	"os"
+	"testing"
+)
+
+func TestSvc_Render(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	m := NewSvc(fs, "/test", logrus.New())
+	setupWithTestChart(t, fs)
+	if err := afero.WriteFile(fs, "/test/resources/file.yml", []byte("metadata:\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	deploy := &cfg.Deploy{
+		Chart:       "test-0.1.0.tgz",
+		Environment: "env",
+		Component:   "app",
+		Namespace:   cfg.Namespace{Name: "ns"},
+		Labels:      map[string]string{"a": "b"},
+		With:        cfg.Withs{"file": {"app": cfg.With{Path: "apps/app.yaml"}}},
+		Chain:       []string{"helm", "with", "labels"},
+	}
+
+	var out bytes.Buffer
+	assert.NilError(t, m.Render(deploy, RenderOptions{WithPaths: true}, &out))
+	expected := `# Source: test/templates/test.yaml
+test:
+metadata:
+  labels:
+    a: b
+---
+# Source: simple-ops with path apps/app.yaml
+metadata:
+  name: app
+`
+	assert.Equal(t, out.String(), expected)
+
+	out.Reset()
+	assert.NilError(t, m.Render(deploy, RenderOptions{Until: "helm"}, &out))
+	assert.Equal(t, out.String(), "---\n# Source: test/templates/test.yaml\ntest:\n")
+
+	_, err := fs.Stat("/test/apps")
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = fs.Stat("/test/deploy/env")
+	assert.Assert(t, os.IsNotExist(err))
+
+	assert.Error(t, m.Render(deploy, RenderOptions{Until: "kustomize"}, &out), "step kustomize is not in the chain of env.app: helm, with, labels")
+	deploy.Disabled = true
+	assert.Error(t, m.Render(deploy, RenderOptions{}, &out), "deploy env.app is disabled")
+}